@@ -0,0 +1,170 @@
+package tinyrpc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+	"tinyrpc/status"
+)
+
+// AuthorizationKey the metadata key of the credentials, the value looks like "Bearer <token>"
+const AuthorizationKey = "authorization"
+
+var (
+	ErrMissingToken = errors.New("missing bearer token")
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token is expired")
+)
+
+// Principal is the authenticated caller
+type Principal struct {
+	Subject string
+	Roles   []string               // roles checked by the Policy
+	Claims  map[string]interface{} // claims of the token, nil if the token has none
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the caller authenticated by the Authenticator of the server
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// Authenticator authenticates the caller by the request metadata
+type Authenticator interface {
+	Authenticate(ctx context.Context, md map[string]string) (*Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to Authenticator
+type AuthenticatorFunc func(ctx context.Context, md map[string]string) (*Principal, error)
+
+// Authenticate calls f
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, md map[string]string) (*Principal, error) {
+	return f(ctx, md)
+}
+
+// WithAuthenticator authenticates every call by the metadata of the request header,
+// before the body is read. Unauthenticated calls are rejected with status.Unauthenticated
+// and their bodies are discarded without being decompressed or decoded. The
+// Authenticator runs on the read loop of the connection, so it should not block.
+func WithAuthenticator(a Authenticator) Option {
+	return func(o *options) {
+		o.authenticator = a
+	}
+}
+
+// authenticate puts the principal authenticated by a into the call context
+func authenticate(a Authenticator) admission {
+	return func(ctx context.Context, serviceMethod string) (context.Context, error) {
+		md, _ := MetadataFromContext(ctx)
+		p, err := a.Authenticate(ctx, md)
+		if err != nil {
+			if s, ok := status.FromError(err); ok {
+				return ctx, s
+			}
+			return ctx, status.New(status.Unauthenticated, err.Error())
+		}
+		return context.WithValue(ctx, principalKey{}, p), nil
+	}
+}
+
+// BearerToken returns the token of the authorization metadata
+func BearerToken(md map[string]string) (string, error) {
+	const prefix = "bearer "
+	v := md[AuthorizationKey]
+	if len(v) <= len(prefix) || !strings.EqualFold(v[:len(prefix)], prefix) {
+		return "", ErrMissingToken
+	}
+	return v[len(prefix):], nil
+}
+
+// staticAuthenticator 以 token 的摘要查找，避免按 token 内容比较的耗时差异
+type staticAuthenticator map[[sha256.Size]byte]string
+
+// NewStaticAuthenticator authenticates the bearer tokens, tokens maps each token to its subject
+func NewStaticAuthenticator(tokens map[string]string) Authenticator {
+	a := make(staticAuthenticator, len(tokens))
+	for token, subject := range tokens {
+		a[sha256.Sum256([]byte(token))] = subject
+	}
+	return a
+}
+
+// Authenticate .
+func (a staticAuthenticator) Authenticate(_ context.Context, md map[string]string) (*Principal, error) {
+	token, err := BearerToken(md)
+	if err != nil {
+		return nil, err
+	}
+	subject, ok := a[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return &Principal{Subject: subject}, nil
+}
+
+// hmacClaims the payload of the HMAC token
+type hmacClaims struct {
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// NewHMACToken creates a token of the subject signed with key, it expires after ttl.
+// The token is base64url(payload) "." base64url(HMAC-SHA256(base64url(payload))).
+func NewHMACToken(key []byte, subject string, ttl time.Duration) (string, error) {
+	payload, err := json.Marshal(hmacClaims{Subject: subject, ExpiresAt: time.Now().Add(ttl).Unix()})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(hmacSum(key, encoded)), nil
+}
+
+func hmacSum(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+type hmacAuthenticator struct {
+	key []byte
+}
+
+// NewHMACAuthenticator authenticates the bearer tokens created by NewHMACToken with key
+func NewHMACAuthenticator(key []byte) Authenticator {
+	return &hmacAuthenticator{key: key}
+}
+
+// Authenticate .
+func (a *hmacAuthenticator) Authenticate(_ context.Context, md map[string]string) (*Principal, error) {
+	token, err := BearerToken(md)
+	if err != nil {
+		return nil, err
+	}
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, hmacSum(a.key, encoded)) {
+		return nil, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims hmacClaims
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	return &Principal{Subject: claims.Subject}, nil
+}
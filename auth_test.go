@@ -0,0 +1,413 @@
+package tinyrpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tinyrpc/compressor"
+	"tinyrpc/serializer"
+	"tinyrpc/status"
+	js "tinyrpc/test_gen/json"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func bearer(token string) map[string]string {
+	return map[string]string{AuthorizationKey: "Bearer " + token}
+}
+
+// signJWT signs the claims with key, key is []byte, *rsa.PrivateKey or *ecdsa.PrivateKey
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	return signJWTHeader(t, map[string]interface{}{"alg": alg, "typ": "JWT", "kid": kid}, key, claims)
+}
+
+// signJWTHeader signs the claims with the header, the alg of the header selects the hash
+func signJWTHeader(t *testing.T, fields map[string]interface{}, key interface{}, claims map[string]interface{}) string {
+	alg, _ := fields["alg"].(string)
+	header, err := json.Marshal(fields)
+	assert.Equal(t, nil, err)
+	payload, err := json.Marshal(claims)
+	assert.Equal(t, nil, err)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	hash, ok := jwtAlgs[alg]
+	if !ok { // 不签名，如 alg 为 none
+		return input + "."
+	}
+	h := hash.New()
+	h.Write([]byte(input))
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(hash.New, k)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, hash, h.Sum(nil))
+		assert.Equal(t, nil, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, h.Sum(nil))
+		assert.Equal(t, nil, err)
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestStaticAuthenticator(t *testing.T) {
+	a := NewStaticAuthenticator(map[string]string{"t0ken": "alice"})
+	cases := []struct {
+		name    string
+		md      map[string]string
+		subject string
+		err     error
+	}{
+		{"test-1", bearer("t0ken"), "alice", nil},
+		{"test-2", map[string]string{AuthorizationKey: "bearer t0ken"}, "alice", nil},
+		{"test-3", bearer("other"), "", ErrInvalidToken},
+		{"test-4", map[string]string{AuthorizationKey: "Basic t0ken"}, "", ErrMissingToken},
+		{"test-5", nil, "", ErrMissingToken},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := a.Authenticate(context.Background(), c.md)
+			assert.Equal(t, c.err, err)
+			if err == nil {
+				assert.Equal(t, c.subject, p.Subject)
+			}
+		})
+	}
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	key := []byte("secret")
+	a := NewHMACAuthenticator(key)
+	valid, err := NewHMACToken(key, "alice", time.Minute)
+	assert.Equal(t, nil, err)
+	expired, err := NewHMACToken(key, "alice", -time.Minute)
+	assert.Equal(t, nil, err)
+	forged, err := NewHMACToken([]byte("other"), "alice", time.Minute)
+	assert.Equal(t, nil, err)
+
+	cases := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"test-1", valid, nil},
+		{"test-2", expired, ErrTokenExpired},
+		{"test-3", forged, ErrInvalidToken},
+		{"test-4", "no-signature", ErrInvalidToken},
+		{"test-5", valid + "x", ErrInvalidToken},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := a.Authenticate(context.Background(), bearer(c.token))
+			assert.Equal(t, c.err, err)
+			if err == nil {
+				assert.Equal(t, "alice", p.Subject)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	hmacKey := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Equal(t, nil, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Equal(t, nil, err)
+
+	a := NewJWTAuthenticator(JWTConfig{
+		Keys: map[string]interface{}{
+			"":    hmacKey,
+			"rsa": &rsaKey.PublicKey,
+			"ec":  &ecKey.PublicKey,
+		},
+		Issuer:   "tinyrpc",
+		Audience: "arith",
+		Leeway:   5 * time.Second,
+	})
+	now := time.Now().Unix()
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "alice", "iss": "tinyrpc", "aud": "arith", "exp": now + 60,
+			"roles": []string{"reader"}}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	cases := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"test-1", signJWT(t, "HS256", "", hmacKey, claims(nil)), nil},
+		{"test-2", signJWT(t, "HS512", "", hmacKey, claims(nil)), nil},
+		{"test-3", signJWT(t, "RS256", "rsa", rsaKey, claims(nil)), nil},
+		{"test-4", signJWT(t, "ES256", "ec", ecKey, claims(nil)), nil},
+		{"test-5", signJWT(t, "HS256", "", hmacKey, claims(map[string]interface{}{
+			"aud": []string{"other", "arith"}})), nil},
+		{"test-6", signJWT(t, "HS256", "", hmacKey, claims(map[string]interface{}{"exp": now - 60})), ErrTokenExpired},
+		{"test-7", signJWT(t, "HS256", "", hmacKey, claims(map[string]interface{}{"exp": now - 1})), nil}, // leeway
+		{"test-8", signJWT(t, "HS256", "", hmacKey, claims(map[string]interface{}{"nbf": now + 60})), ErrInvalidToken},
+		{"test-9", signJWT(t, "HS256", "", hmacKey, claims(map[string]interface{}{"iss": "other"})), ErrInvalidToken},
+		{"test-10", signJWT(t, "HS256", "", hmacKey, claims(map[string]interface{}{"aud": "other"})), ErrInvalidToken},
+		{"test-11", signJWT(t, "HS256", "", []byte("other"), claims(nil)), ErrInvalidToken},
+		{"test-12", signJWT(t, "HS256", "unknown", hmacKey, claims(nil)), ErrUnknownKey},
+		// HMAC 密钥不能用于 RSA 签名的令牌，反之亦然
+		{"test-13", signJWT(t, "RS256", "", rsaKey, claims(nil)), ErrUnknownKey},
+		{"test-14", signJWT(t, "HS256", "rsa", hmacKey, claims(nil)), ErrUnknownKey},
+		{"test-15", signJWT(t, "none", "", nil, claims(nil)), ErrUnsupportedAlg},
+		{"test-16", "a.b", ErrInvalidToken},
+		// exp 与 nbf 必须为数字，不能忽略
+		{"test-17", signJWT(t, "HS256", "", hmacKey, claims(map[string]interface{}{"exp": "never"})), ErrInvalidToken},
+		{"test-18", signJWT(t, "HS256", "", hmacKey, claims(map[string]interface{}{"nbf": true})), ErrInvalidToken},
+		// 不支持任何 crit 扩展
+		{"test-19", signJWTHeader(t, map[string]interface{}{"alg": "HS256", "crit": []string{"exp"}},
+			hmacKey, claims(nil)), ErrInvalidToken},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := a.Authenticate(context.Background(), bearer(c.token))
+			assert.Equal(t, c.err, err)
+			if err == nil {
+				assert.Equal(t, "alice", p.Subject)
+				assert.Equal(t, "tinyrpc", p.Claims["iss"])
+				assert.Equal(t, []string{"reader"}, p.Roles)
+			}
+		})
+	}
+}
+
+func TestTokenCredentials(t *testing.T) {
+	var calls int
+	var fail bool
+	expiry := time.Now().Add(time.Hour)
+	creds := NewTokenCredentials(func() (string, time.Time, error) {
+		if fail {
+			return "", time.Time{}, errors.New("token source is down")
+		}
+		calls++
+		return "t0ken", expiry, nil
+	})
+
+	md, err := creds.Metadata("ArithService.Add")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, bearer("t0ken"), md)
+	_, err = creds.Metadata("ArithService.Add")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, calls)
+
+	// 临近过期时刷新
+	expiry = time.Now().Add(tokenRefreshMargin / 2)
+	c := creds.(*tokenCredentials)
+	c.expiry = expiry
+	_, err = creds.Metadata("ArithService.Add")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, calls)
+	_, err = creds.Metadata("ArithService.Add")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, calls)
+
+	fail = true
+	_, err = creds.Metadata("ArithService.Add")
+	assert.Equal(t, errors.New("token source is down"), err)
+
+	md, err = StaticToken("abc").Metadata("ArithService.Add")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, bearer("abc"), md)
+}
+
+// blockingCredentials blocks the metadata of ArithService.Mul until release is closed
+type blockingCredentials struct {
+	entered, release chan struct{}
+}
+
+func (c *blockingCredentials) Metadata(serviceMethod string) (map[string]string, error) {
+	if serviceMethod == "ArithService.Mul" {
+		close(c.entered)
+		<-c.release
+	}
+	return bearer("t0ken"), nil
+}
+
+// TestCredentials_Stateful a slow token source must not block the writes of other
+// requests, the credentials are resolved before the requests are built in order
+func TestCredentials_Stateful(t *testing.T) {
+	conn, err := net.Dial("tcp", ":8018")
+	assert.Equal(t, nil, err)
+	creds := &blockingCredentials{make(chan struct{}), make(chan struct{})}
+	client := NewClient(conn, WithSerializer(serializer.NewGobSerializer()), WithCredentials(creds))
+	defer client.Close()
+
+	// 单向请求不经过 net/rpc 的请求锁，只与其他请求共享连接的写端
+	slow := make(chan error, 1)
+	go func() {
+		slow <- client.Notify("ArithService.Mul", &js.ArithRequest{A: 20, B: 5})
+	}()
+	<-creds.entered
+	done := make(chan error, 1)
+	reply := &js.ArithResponse{}
+	go func() {
+		done <- client.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, reply)
+	}()
+	select {
+	case err = <-done:
+		assert.Equal(t, nil, err)
+		assert.Equal(t, float64(25), reply.C)
+	case <-time.After(2 * time.Second):
+		t.Error("the call is blocked by the credentials of another call")
+	}
+	close(creds.release)
+	assert.Equal(t, nil, <-slow)
+}
+
+func TestServer_Authenticator(t *testing.T) {
+	key := []byte("secret")
+	var mu sync.Mutex
+	var subjects []string
+	record := func(ctx context.Context, info *CallInfo, next Handler) error {
+		p, ok := PrincipalFromContext(ctx)
+		assert.True(t, ok)
+		md, _ := MetadataFromContext(ctx)
+		assert.Equal(t, "v1", md["x-version"])
+		mu.Lock()
+		subjects = append(subjects, p.Subject)
+		mu.Unlock()
+		return next(ctx, info)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	server := NewServer(WithSerializer(serializer.NewJsonSerializer()),
+		WithAuthenticator(NewHMACAuthenticator(key)), WithInterceptor(record))
+	assert.Equal(t, nil, server.Register(new(js.ArithService)))
+	go server.Serve(lis)
+
+	dial := func(creds Credentials) *Client {
+		conn, err := net.Dial("tcp", lis.Addr().String())
+		assert.Equal(t, nil, err)
+		opts := []Option{WithSerializer(serializer.NewJsonSerializer())}
+		if creds != nil {
+			opts = append(opts, WithCredentials(creds))
+		}
+		return NewClient(conn, opts...)
+	}
+	versioned := func(creds Credentials) Credentials {
+		return credentialsFunc(func(serviceMethod string) (map[string]string, error) {
+			md, err := creds.Metadata(serviceMethod)
+			if err != nil {
+				return nil, err
+			}
+			out := map[string]string{"x-version": "v1"}
+			for k, v := range md {
+				out[k] = v
+			}
+			return out, nil
+		})
+	}
+
+	client := dial(versioned(HMACCredentials(key, "alice", time.Minute)))
+	defer client.Close()
+	reply := &js.ArithResponse{}
+	err = client.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(25), reply.C)
+	assert.Equal(t, []string{"alice"}, subjects)
+
+	// 未携带令牌或令牌无效时在拦截器之前拒绝
+	anonymous := dial(nil)
+	defer anonymous.Close()
+	err = anonymous.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, &js.ArithResponse{})
+	assert.Equal(t, status.Unauthenticated, status.Convert(err).Code())
+	forged := dial(HMACCredentials([]byte("other"), "alice", time.Minute))
+	defer forged.Close()
+	err = forged.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, &js.ArithResponse{})
+	assert.Equal(t, status.Unauthenticated, status.Convert(err).Code())
+	assert.Equal(t, 1, len(subjects))
+
+	// 凭证出错时调用失败，请求不会发出
+	broken := dial(NewTokenCredentials(func() (string, time.Time, error) {
+		return "", time.Time{}, errors.New("token source is down")
+	}))
+	defer broken.Close()
+	err = broken.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, &js.ArithResponse{})
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, 1, len(subjects))
+}
+
+// countingCompressor counts the bodies it decompresses
+type countingCompressor struct {
+	unzipped *int32
+}
+
+func (c countingCompressor) Zip(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (c countingCompressor) Unzip(data []byte) ([]byte, error) {
+	atomic.AddInt32(c.unzipped, 1)
+	return data, nil
+}
+
+func TestServer_AuthenticatorDiscardsBody(t *testing.T) {
+	var unzipped int32
+	ct := compressor.UserDefined + 10
+	err := compressor.Register(ct, "counting", func(compressor.Options) (compressor.Compressor, error) {
+		return countingCompressor{&unzipped}, nil
+	})
+	assert.Equal(t, nil, err)
+	t.Cleanup(func() { compressor.Unregister(ct) })
+
+	key := []byte("secret")
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	server := NewServer(WithSerializer(serializer.NewJsonSerializer()),
+		WithAuthenticator(NewHMACAuthenticator(key)))
+	assert.Equal(t, nil, server.Register(new(js.ArithService)))
+	go server.Serve(lis)
+
+	dial := func(opts ...Option) *Client {
+		conn, err := net.Dial("tcp", lis.Addr().String())
+		assert.Equal(t, nil, err)
+		return NewClient(conn, append(opts, WithSerializer(serializer.NewJsonSerializer()), WithCompress(ct))...)
+	}
+
+	// 未认证的请求体不解压，连接上的后续请求不受影响
+	anonymous := dial()
+	defer anonymous.Close()
+	for i := 0; i < 3; i++ {
+		err = anonymous.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, &js.ArithResponse{})
+		assert.Equal(t, status.Unauthenticated, status.Convert(err).Code())
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&unzipped))
+
+	client := dial(WithCredentials(HMACCredentials(key, "alice", time.Minute)))
+	defer client.Close()
+	reply := &js.ArithResponse{}
+	err = client.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(25), reply.C)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&unzipped)) // 请求与响应各解压一次
+}
+
+type credentialsFunc func(serviceMethod string) (map[string]string, error)
+
+func (f credentialsFunc) Metadata(serviceMethod string) (map[string]string, error) {
+	return f(serviceMethod)
+}
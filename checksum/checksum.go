@@ -0,0 +1,99 @@
+package checksum
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+type Type uint8
+
+const (
+	None Type = iota
+	CRC32
+	CRC32C
+	XXHash64
+	HMACSHA256
+)
+
+var (
+	// ErrNotFoundChecksum refers to a checksum type that is not registered
+	ErrNotFoundChecksum = errors.New("not found checksum")
+	// ErrMissingKey refers to a keyed checksum created without a key
+	ErrMissingKey = errors.New("checksum key is required")
+	// ErrChecksumTypeExists refers to a checksum type that is already registered
+	ErrChecksumTypeExists = errors.New("checksum type already registered")
+)
+
+var registry = struct {
+	sync.RWMutex
+	types map[Type]Checksum
+}{
+	types: make(map[Type]Checksum),
+}
+
+// Checksum 计算请求体与响应体的校验值
+type Checksum interface {
+	Type() Type
+	Sum(data []byte) []byte
+}
+
+// Get returns the checksum of type t, keyed checksums are created with key
+func Get(t Type, key []byte) (Checksum, error) {
+	if t == HMACSHA256 {
+		if len(key) == 0 {
+			return nil, ErrMissingKey
+		}
+		return NewHMACSHA256(key), nil
+	}
+	c, ok := Lookup(t)
+	if !ok {
+		return nil, ErrNotFoundChecksum
+	}
+	return c, nil
+}
+
+// Register registers the unkeyed checksum c of its type, it fails if the type is taken.
+// HMACSHA256 is created by Get with the key and can not be registered.
+func Register(c Checksum) error {
+	t := c.Type()
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.types[t]; ok || t == HMACSHA256 {
+		return fmt.Errorf("%w: %d", ErrChecksumTypeExists, t)
+	}
+	registry.types[t] = c
+	return nil
+}
+
+// mustRegister registers the builtin checksums
+func mustRegister(c Checksum) {
+	if err := Register(c); err != nil {
+		panic(err)
+	}
+}
+
+// Unregister removes the checksum of t, so t can be registered again
+func Unregister(t Type) error {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.types[t]; !ok {
+		return ErrNotFoundChecksum
+	}
+	delete(registry.types, t)
+	return nil
+}
+
+// Lookup returns the registered checksum of t
+func Lookup(t Type) (Checksum, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	c, ok := registry.types[t]
+	return c, ok
+}
+
+// Verify reports whether sum is the checksum of data, the comparison is constant time
+func Verify(c Checksum, data []byte, sum []byte) bool {
+	return subtle.ConstantTimeCompare(c.Sum(data), sum) == 1
+}
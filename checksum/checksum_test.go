@@ -0,0 +1,82 @@
+package checksum
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGet(t *testing.T) {
+	type expect struct {
+		sum []byte
+		err error
+	}
+	cases := []struct {
+		name   string
+		t      Type
+		key    []byte
+		expect expect
+	}{
+		{"none", None, nil, expect{nil, nil}},
+		{"crc32", CRC32, nil, expect{[]byte{0x0d, 0x4a, 0x11, 0x85}, nil}},
+		{"crc32c", CRC32C, nil, expect{[]byte{0xc9, 0x94, 0x65, 0xaa}, nil}},
+		{"xxhash64", XXHash64, nil, expect{[]byte{0x45, 0xab, 0x67, 0x34, 0xb2, 0x1e, 0x69, 0x68}, nil}},
+		{"hmac-sha256", HMACSHA256, []byte("key"), expect{[]byte{
+			0x0b, 0xa0, 0x6f, 0x1f, 0x9a, 0x63, 0x00, 0x46, 0x1e, 0x43, 0x45, 0x45, 0x35, 0xdc, 0x3c, 0x42,
+			0x23, 0xe4, 0x7b, 0x1d, 0x35, 0x70, 0x73, 0xd7, 0x53, 0x6e, 0xae, 0x90, 0xec, 0x09, 0x5b, 0xe1}, nil}},
+		{"hmac-sha256 without key", HMACSHA256, nil, expect{nil, ErrMissingKey}},
+		{"unknown", Type(100), nil, expect{nil, ErrNotFoundChecksum}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sum, err := Get(c.t, c.key)
+			assert.Equal(t, c.expect.err, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, c.t, sum.Type())
+			assert.Equal(t, c.expect.sum, sum.Sum([]byte("hello world")))
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	data := []byte("hello world")
+	sum := NewHMACSHA256([]byte("key"))
+	assert.Equal(t, true, Verify(sum, data, sum.Sum(data)))
+	assert.Equal(t, false, Verify(sum, []byte("hello World"), sum.Sum(data)))
+	assert.Equal(t, false, Verify(NewHMACSHA256([]byte("other")), data, sum.Sum(data)))
+}
+
+// reverseChecksum a custom checksum for the registry tests
+type reverseChecksum struct{}
+
+func (reverseChecksum) Type() Type {
+	return Type(200)
+}
+
+func (reverseChecksum) Sum(data []byte) []byte {
+	sum := make([]byte, len(data))
+	for i, b := range data {
+		sum[len(data)-1-i] = b
+	}
+	return sum
+}
+
+func TestRegister(t *testing.T) {
+	assert.Equal(t, nil, Register(reverseChecksum{}))
+	t.Cleanup(func() { Unregister(Type(200)) })
+	assert.Equal(t, true, errors.Is(Register(reverseChecksum{}), ErrChecksumTypeExists))
+	assert.Equal(t, true, errors.Is(Register(NewCRC32Checksum()), ErrChecksumTypeExists))
+	assert.Equal(t, true, errors.Is(Register(NewHMACSHA256([]byte("key"))), ErrChecksumTypeExists))
+
+	c, err := Get(Type(200), nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte("cba"), c.Sum([]byte("abc")))
+
+	assert.Equal(t, nil, Unregister(Type(200)))
+	_, ok := Lookup(Type(200))
+	assert.Equal(t, false, ok)
+	assert.Equal(t, ErrNotFoundChecksum, Unregister(Type(200)))
+}
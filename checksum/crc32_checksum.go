@@ -0,0 +1,39 @@
+package checksum
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+func init() {
+	mustRegister(NewCRC32Checksum())
+	mustRegister(NewCRC32CChecksum())
+}
+
+// castagnoliTable 在支持 SSE4.2/ARMv8 CRC 指令的平台上由硬件加速
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+type CRC32Checksum struct {
+	t     Type
+	table *crc32.Table
+}
+
+// NewCRC32Checksum create a CRC32-IEEE checksum
+func NewCRC32Checksum() Checksum {
+	return &CRC32Checksum{t: CRC32, table: crc32.IEEETable}
+}
+
+// NewCRC32CChecksum create a CRC32C (Castagnoli) checksum
+func NewCRC32CChecksum() Checksum {
+	return &CRC32Checksum{t: CRC32C, table: castagnoliTable}
+}
+
+func (c *CRC32Checksum) Type() Type {
+	return c.t
+}
+
+func (c *CRC32Checksum) Sum(data []byte) []byte {
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.Checksum(data, c.table))
+	return sum
+}
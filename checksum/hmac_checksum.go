@@ -0,0 +1,26 @@
+package checksum
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// HMACSHA256Checksum authenticates data with a shared key, detecting tampering
+// on untrusted links. It is not registered since it needs a key.
+type HMACSHA256Checksum struct {
+	key []byte
+}
+
+func NewHMACSHA256(key []byte) Checksum {
+	return &HMACSHA256Checksum{key: key}
+}
+
+func (*HMACSHA256Checksum) Type() Type {
+	return HMACSHA256
+}
+
+func (c *HMACSHA256Checksum) Sum(data []byte) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,27 @@
+package checksum
+
+import (
+	"encoding/binary"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+func init() {
+	mustRegister(NewXXHash64Checksum())
+}
+
+type XXHash64Checksum struct{}
+
+func NewXXHash64Checksum() Checksum {
+	return &XXHash64Checksum{}
+}
+
+func (*XXHash64Checksum) Type() Type {
+	return XXHash64
+}
+
+func (*XXHash64Checksum) Sum(data []byte) []byte {
+	sum := make([]byte, 8)
+	binary.BigEndian.PutUint64(sum, xxhash.Sum64(data))
+	return sum
+}
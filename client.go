@@ -1,8 +1,14 @@
 package tinyrpc
 
 import (
+	"context"
+	"crypto/tls"
 	"io"
+	"log"
 	"net/rpc"
+	"sync"
+	"time"
+	"tinyrpc/checksum"
 	"tinyrpc/codec"
 	"tinyrpc/compressor"
 	"tinyrpc/serializer"
@@ -12,8 +18,31 @@ import (
 type Option func(o *options)
 
 type options struct {
-	compressType compressor.CompressType
-	serializer   serializer.Serializer
+	compressType      compressor.CompressType
+	compressThreshold int // auto compression threshold, 0 always compresses
+	accept            []compressor.CompressType
+	compressPolicy    codec.CompressPolicy
+	dictID            uint32
+	sampler           *compressor.Sampler
+	serializer        serializer.Serializer
+	checksumType      checksum.Type
+	checksumKey       []byte
+
+	writeBatch    bool          // client coalesces requests into one flush
+	maxWriteDelay time.Duration // max time a request waits in the write buffer
+	maxWriteBytes int           // client write buffer size
+
+	workers        int // server worker pool size
+	queueSize      int // server request queue size
+	connQueueLimit int // max in-flight requests per connection
+	tlsConfig      *tls.Config
+	interceptors   []Interceptor
+	authenticator  Authenticator
+	authorizer     Authorizer
+	rateLimits     *RateLimits
+	adaptiveLimit  *AdaptiveLimit
+	crashOnPanic   bool
+	credentials    Credentials // metadata attached to the calls of the client
 }
 
 // WithCompress set client compression format
@@ -23,6 +52,47 @@ func WithCompress(c compressor.CompressType) Option {
 	}
 }
 
+// WithCompressThreshold enable auto compression: bodies shorter than threshold, and
+// bodies that the compressor doesn't shrink, are sent uncompressed. It applies to
+// requests of a client and responses of a server.
+func WithCompressThreshold(threshold int) Option {
+	return func(o *options) {
+		o.compressThreshold = threshold
+	}
+}
+
+// WithAcceptCompress set the compress types the client accepts for responses in order of
+// preference, by default the server replies with the compress type of the request
+func WithAcceptCompress(types ...compressor.CompressType) Option {
+	return func(o *options) {
+		o.accept = types
+	}
+}
+
+// WithCompressPolicy set how the server chooses the compress type of each response among
+// the types accepted by the client, e.g. codec.SizeCompress(1024, compressor.Zstd)
+func WithCompressPolicy(policy codec.CompressPolicy) Option {
+	return func(o *options) {
+		o.compressPolicy = policy
+	}
+}
+
+// WithDict make the client propose the shared dictionary registered under id by
+// compressor.RegisterDict, zlib and zstd use it once the server accepts it
+func WithDict(id uint32) Option {
+	return func(o *options) {
+		o.dictID = id
+	}
+}
+
+// WithDictSampler make the server capture request and response payloads into the
+// sampler, the dictionary trained by sampler.Train can then be registered on both sides
+func WithDictSampler(sampler *compressor.Sampler) Option {
+	return func(o *options) {
+		o.sampler = sampler
+	}
+}
+
 // WithSerializer set client serializer
 func WithSerializer(serializer serializer.Serializer) Option {
 	return func(o *options) {
@@ -30,9 +100,45 @@ func WithSerializer(serializer serializer.Serializer) Option {
 	}
 }
 
+// WithChecksum set client checksum algorithm, checksum.None skips checksumming
+func WithChecksum(t checksum.Type) Option {
+	return func(o *options) {
+		o.checksumType = t
+	}
+}
+
+// WithChecksumKey set the shared key of checksum.HMACSHA256,
+// a server with a key rejects requests that are not signed with it
+func WithChecksumKey(key []byte) Option {
+	return func(o *options) {
+		o.checksumKey = key
+	}
+}
+
+// WithWriteBatch make the client flush requests from a background write loop. A request
+// waits at most maxDelay, and the buffer is flushed once maxBytes are queued (0 keeps
+// the default size).
+//
+// Deprecated: concurrent calls already share one flush without the write loop, and
+// the loop no longer improves the throughput (see BenchmarkClient_CallWithWriteBatch).
+func WithWriteBatch(maxDelay time.Duration, maxBytes int) Option {
+	return func(o *options) {
+		o.writeBatch = true
+		o.maxWriteDelay = maxDelay
+		o.maxWriteBytes = maxBytes
+	}
+}
+
 // Client rpc client based on net/rpc implementation
 type Client struct {
 	*rpc.Client
+	notifier codec.Notifier
+	opts     []Option
+	codec    codec.Callbacks
+
+	// 回调服务在首次注册服务或服务端首次回调时创建
+	once      sync.Once
+	callbacks *Server // services called by the server over the same connection
 }
 
 // NewClient Create a new rpc client
@@ -41,22 +147,118 @@ func NewClient(conn io.ReadWriteCloser, opts ...Option) *Client {
 	options := options{
 		compressType: compressor.Raw,
 		serializer:   serializer.NewProtoSerializer(),
+		checksumType: checksum.CRC32,
 	}
 	for _, option := range opts {
 		option(&options)
 	}
-	return &Client{
-		rpc.NewClientWithCodec(
-			codec.NewClientCodec(conn, options.compressType, options.serializer)),
+	codecOpts := []codec.Option{
+		codec.WithChecksum(options.checksumType),
+		codec.WithChecksumKey(options.checksumKey),
+		codec.WithCompressThreshold(options.compressThreshold),
+		codec.WithAcceptCompress(options.accept...),
+		codec.WithDict(options.dictID),
+		codec.WithMetadata(credentialsMetadata(options.credentials)),
+	}
+	if options.writeBatch {
+		codecOpts = append(codecOpts, codec.WithWriteBatch(options.maxWriteDelay, options.maxWriteBytes))
 	}
+	cc := codec.NewClientCodec(conn, options.compressType, options.serializer, codecOpts...)
+	c := &Client{
+		notifier: cc.(codec.Notifier),
+		opts:     opts,
+		codec:    cc.(codec.Callbacks),
+	}
+	// 读循环启动前设置，未注册服务时回调返回找不到服务的错误
+	c.codec.OnCallback(func() { c.serveCallbacks() })
+	c.Client = rpc.NewClientWithCodec(cc)
+	return c
+}
+
+// serveCallbacks creates the callback server and serves the callback codec once
+func (c *Client) serveCallbacks() *Server {
+	c.once.Do(func() {
+		c.callbacks = NewServer(c.opts...)
+		go c.callbacks.serveCodec(context.Background(), c.codec.CallbackCodec())
+	})
+	return c.callbacks
+}
+
+// Register register rpc function that the server calls back through Peer
+func (c *Client) Register(rcvr interface{}) error {
+	return c.serveCallbacks().Register(rcvr)
 }
 
-// Call synchronously calls the rpc function
+// RegisterName register the rpc function called back by the server with the specified name
+func (c *Client) RegisterName(name string, rcvr interface{}) error {
+	return c.serveCallbacks().RegisterName(name, rcvr)
+}
+
+// Close closes the connection and stops the callback server if it was started
+func (c *Client) Close() error {
+	err := c.Client.Close()
+	c.once.Do(func() {})
+	if c.callbacks != nil {
+		c.callbacks.Close()
+	}
+	return err
+}
+
+// Call synchronously calls the rpc function, the error is a *ResponseError if
+// the response carries metadata
 func (c *Client) Call(serviceMethod string, args interface{}, reply interface{}) error {
-	return c.Client.Call(serviceMethod, args, reply)
+	_, err := c.CallWithMetadata(serviceMethod, args, reply)
+	return err
 }
 
-// AsyncCall asynchronously calls the rpc function and returns a channel of *rpc.Call
+// CallWithMetadata synchronously calls the rpc function like Call and returns the
+// metadata of the response, which is also returned by successful calls
+func (c *Client) CallWithMetadata(serviceMethod string, args interface{},
+	reply interface{}) (map[string]string, error) {
+	call := &codec.Call{Args: args}
+	err := c.Client.Call(serviceMethod, call, reply)
+	if err != nil && call.Metadata != nil {
+		err = &ResponseError{Err: err, Metadata: call.Metadata}
+	}
+	return call.Metadata, err
+}
+
+// Go asynchronously calls the rpc function like rpc.Client.Go, the Error of the call
+// is a *ResponseError if the response carries metadata. The metadata of successful
+// responses is only returned by CallWithMetadata.
+func (c *Client) Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
+	if done == nil {
+		done = make(chan *rpc.Call, 10) // buffered.
+	} else if cap(done) == 0 {
+		log.Panic("rpc: done channel is unbuffered")
+	}
+	result := &rpc.Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Done: done}
+	call := &codec.Call{Args: args}
+	pending := c.Client.Go(serviceMethod, call, reply, make(chan *rpc.Call, 1))
+	go func() {
+		<-pending.Done
+		result.Error = pending.Error
+		if pending.Error != nil && call.Metadata != nil {
+			result.Error = &ResponseError{Err: pending.Error, Metadata: call.Metadata}
+		}
+		select {
+		case done <- result:
+		default:
+			// 与 rpc.Client 相同，done 容量不足时丢弃
+			log.Println("rpc: discarding Call reply due to insufficient Done chan capacity")
+		}
+	}()
+	return result
+}
+
+// AsyncCall asynchronously calls the rpc function and returns a channel of *rpc.Call,
+// the Error of the call is a *ResponseError if the response carries metadata
 func (c *Client) AsyncCall(serviceMethod string, args interface{}, reply interface{}) chan *rpc.Call {
 	return c.Go(serviceMethod, args, reply, nil).Done
 }
+
+// Notify sends a one-way call, the server executes it but never responds,
+// so only errors that occur while sending are returned
+func (c *Client) Notify(serviceMethod string, args interface{}) error {
+	return c.notifier.Notify(serviceMethod, args)
+}
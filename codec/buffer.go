@@ -0,0 +1,35 @@
+package codec
+
+import "sync"
+
+// maxPooledBuffer 超过该大小的缓冲区不放回缓冲池，避免个别大消息长期占用内存
+const maxPooledBuffer = 64 << 10
+
+// buffer 序列化消息时复用的缓冲区
+type buffer struct {
+	b []byte
+}
+
+// Write implements io.Writer for serializer.Encoder
+func (buf *buffer) Write(p []byte) (int, error) {
+	buf.b = append(buf.b, p...)
+	return len(p), nil
+}
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(buffer)
+	},
+}
+
+func getBuffer() *buffer {
+	return bufferPool.Get().(*buffer)
+}
+
+func putBuffer(buf *buffer) {
+	if cap(buf.b) > maxPooledBuffer {
+		return
+	}
+	buf.b = buf.b[:0]
+	bufferPool.Put(buf)
+}
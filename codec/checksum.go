@@ -0,0 +1,30 @@
+package codec
+
+import "tinyrpc/checksum"
+
+// unsignedHeader encodes a header without its checksum
+type unsignedHeader interface {
+	Unsigned() []byte
+}
+
+// frameData returns the data covered by the checksum of a frame. Keyed checksums
+// also cover the header, so the method, the ID, the flags and the metadata can't
+// be altered without the key.
+func frameData(t checksum.Type, h unsignedHeader, body []byte) []byte {
+	if t != checksum.HMACSHA256 {
+		return body
+	}
+	hdr := h.Unsigned()
+	data := make([]byte, 0, len(hdr)+len(body))
+	return append(append(data, hdr...), body...)
+}
+
+// sumFrame returns the checksum of a frame, it is called after the other fields of h are set
+func sumFrame(sum checksum.Checksum, h unsignedHeader, body []byte) []byte {
+	return sum.Sum(frameData(sum.Type(), h, body))
+}
+
+// verifyFrame reports whether expected is the checksum of the frame
+func verifyFrame(sum checksum.Checksum, h unsignedHeader, body []byte, expected []byte) bool {
+	return checksum.Verify(sum, frameData(sum.Type(), h, body), expected)
+}
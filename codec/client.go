@@ -2,10 +2,11 @@ package codec
 
 import (
 	"bufio"
-	"hash/crc32"
 	"io"
 	"net/rpc"
 	"sync"
+	"sync/atomic"
+	"tinyrpc/checksum"
 	"tinyrpc/compressor"
 	"tinyrpc/header"
 	"tinyrpc/serializer"
@@ -13,120 +14,348 @@ import (
 
 type clientCodec struct {
 	r io.Reader
-	w io.Writer
+	w *connWriter
 	c io.Closer
+	// conn is the connection, closed when the stateful session is out of sync
+	conn io.Closer
 
-	compressor compressor.CompressType // rpc compress type(raw,gzip,snappy,zlib)
-	serializer serializer.Serializer
-	response   header.ResponseHeader // rpc response header
-	mu         sync.Mutex            // protect pending map
-	pending    map[uint64]string
+	compressor  compressor.CompressType   // rpc compress type(raw,gzip,snappy,zlib)
+	threshold   int                       // auto compression threshold
+	accept      []compressor.CompressType // compress types accepted for responses
+	dictID      uint32                    // shared dictionary proposed to the server
+	dictOK      int32                     // the server accepted dictID, requests may use it
+	metadata    MetadataFunc
+	serializer  serializer.Serializer
+	checksum    checksum.Type // rpc checksum type(none,crc32,crc32c,xxhash64,hmac-sha256)
+	checksumKey []byte
+	stateful    bool                  // serializer is a session of serializer.Stateful
+	response    header.ResponseHeader // rpc response header
+	body        []byte                // response body read with the header to verify it
+	mu          sync.Mutex            // protect pending map
+	pending     map[uint64]pendingCall
+
+	ownWriter  bool       // close w when the codec is closed
+	callbacks  *framePipe // requests issued by the server are forwarded to callbackCodec
+	callback   *serverCodec
+	onCallback func()
+	notified   sync.Once
 }
 
 // NewClientCodec Create a new client codec
 func NewClientCodec(conn io.ReadWriteCloser,
 	compressType compressor.CompressType,
-	serializer serializer.Serializer) rpc.ClientCodec {
+	serializer serializer.Serializer, opts ...Option) rpc.ClientCodec {
+	options := newOptions(opts)
+	c := newClientCodec(bufio.NewReader(conn), newConnWriter(conn, options), conn,
+		compressType, serializer, options)
+	c.ownWriter = true
+
+	p := newFramePipe()
+	c.callbacks = p
+	c.callback = newServerCodec(bufio.NewReader(p), c.w, p, serializer, options)
+	c.callback.conn = conn
+	return c
+}
+
+func newClientCodec(r io.Reader, w *connWriter, c io.Closer,
+	compressType compressor.CompressType,
+	serializer serializer.Serializer, options options) *clientCodec {
+	accept := options.accept
+	if len(accept) == 0 {
+		accept = []compressor.CompressType{compressType}
+	}
+	serializer, stateful := newSession(serializer)
 	return &clientCodec{
-		r:          bufio.NewReader(conn),
-		w:          bufio.NewWriter(conn),
-		c:          conn,
-		compressor: compressType,
-		serializer: serializer,
-		pending:    make(map[uint64]string),
+		r:           r,
+		w:           w,
+		c:           c,
+		conn:        c,
+		compressor:  compressType,
+		threshold:   options.compressThreshold,
+		accept:      accept,
+		dictID:      options.dictID,
+		metadata:    options.metadata,
+		serializer:  serializer,
+		stateful:    stateful,
+		checksum:    options.checksumType,
+		checksumKey: options.checksumKey,
+		pending:     make(map[uint64]pendingCall),
 	}
 }
 
+// Callbacks is implemented by the tinyrpc client codec, the returned codec serves the
+// requests issued by the server over the same connection. Callback frames are buffered
+// until the codec is served, so it may be served lazily once OnCallback fires.
+type Callbacks interface {
+	CallbackCodec() rpc.ServerCodec
+	// OnCallback sets f, called once before the first callback is buffered.
+	// It must be set before the codec reads responses.
+	OnCallback(f func())
+}
+
+// CallbackCodec returns the server codec of the requests issued by the server
+func (c *clientCodec) CallbackCodec() rpc.ServerCodec {
+	return c.callback
+}
+
+// OnCallback sets the function called before the first callback is buffered
+func (c *clientCodec) OnCallback(f func()) {
+	c.onCallback = f
+}
+
+// Notifier writes one-way requests, the server executes them without responding
+type Notifier interface {
+	Notify(serviceMethod string, param interface{}) error
+}
+
+// Call wraps the args of a request to receive the metadata of its response,
+// the codec writes Args as the body of the request
+type Call struct {
+	Args     interface{}
+	Metadata map[string]string // metadata of the response, set before the call is done
+}
+
+type pendingCall struct {
+	serviceMethod string
+	call          *Call // nil unless the args are wrapped in a Call
+}
+
 // WriteRequest Write the rpc request header and body to the io stream
 func (c *clientCodec) WriteRequest(r *rpc.Request, param interface{}) error {
+	call, ok := param.(*Call)
+	if ok {
+		param = call.Args
+	}
 	c.mu.Lock()
-	c.pending[r.Seq] = r.ServiceMethod
+	c.pending[r.Seq] = pendingCall{serviceMethod: r.ServiceMethod, call: call}
 	c.mu.Unlock()
 
-	cpr, ok := compressor.Compressors[c.compressor]
-	if !ok {
-		return ErrNotFoundCompressor
+	return c.writeRequest(r.Seq, r.ServiceMethod, param, 0)
+}
+
+// Notify Write a one-way request, it is not tracked in the pending map
+// since no response will be received.
+func (c *clientCodec) Notify(serviceMethod string, param interface{}) error {
+	return c.writeRequest(0, serviceMethod, param, header.FlagOneWay)
+}
+
+func (c *clientCodec) writeRequest(seq uint64, serviceMethod string, param interface{}, flags header.Flag) error {
+	// 元数据可能需要较长时间获取（如刷新令牌），在持有写锁构建请求前取得
+	var metadata map[string]string
+	if c.metadata != nil {
+		var err error
+		if metadata, err = c.metadata(serviceMethod); err != nil {
+			return err
+		}
 	}
-	reqBody, err := c.serializer.Marshal(param)
+	buf := getBuffer() // 写出后放回缓冲池
+	defer putBuffer(buf)
+	return c.w.writeBuiltFrame(requestFrame, c.stateful, func() ([]byte, []byte, error) {
+		return c.buildRequest(seq, serviceMethod, param, metadata, flags, buf)
+	})
+}
+
+func (c *clientCodec) buildRequest(seq uint64, serviceMethod string, param interface{},
+	metadata map[string]string, flags header.Flag, buf *buffer) ([]byte, []byte, error) {
+	sum, err := checksum.Get(c.checksum, c.checksumKey)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	compressedReqBody, err := cpr.Zip(reqBody)
+	reqBody, err := marshal(c.serializer, c.stateful, param, buf)
 	if err != nil {
-		return err
+		return nil, nil, desync(c.stateful, c.conn, err)
+	}
+	var dictID uint32
+	if atomic.LoadInt32(&c.dictOK) == 1 {
+		dictID = c.dictID
+	}
+	compressType, dictID, compressedReqBody, err := compress(c.compressor, dictID, c.threshold, reqBody)
+	if err != nil {
+		return nil, nil, desync(c.stateful, c.conn, err)
+	}
+	if dictID != 0 {
+		flags |= header.FlagDict
 	}
 	h := header.RequestPool.Get().(*header.RequestHeader)
 	defer func() {
 		h.ResetHeader()
 		header.RequestPool.Put(h)
 	}()
-	h.ID = r.Seq
-	h.Method = r.ServiceMethod
+	h.ID = seq
+	h.Method = serviceMethod
 	h.RequestLen = uint32(len(compressedReqBody))
-	h.CompressType = c.compressor
-	h.Checksum = crc32.ChecksumIEEE(compressedReqBody)
-
-	if err := sendFrame(c.w, h.Marshal()); err != nil {
-		return err
+	h.CompressType = compressType
+	h.ChecksumType = c.checksum
+	h.Flags = flags
+	h.DictID = c.dictID
+	h.Metadata = metadata
+	if flags&header.FlagOneWay == 0 {
+		h.Accept = c.accept
 	}
+	h.Checksum = sumFrame(sum, h, compressedReqBody)
 
-	if err := write(c.w, compressedReqBody); err != nil {
-		return err
-	}
-	c.w.(*bufio.Writer).Flush()
-	return nil
+	return h.Marshal(), compressedReqBody, nil
 }
 
-// ReadResponseHeader read the rpc response header from the io stream
+// ReadResponseHeader read the rpc response header from the io stream,
+// requests issued by the server are forwarded to the callback codec
 func (c *clientCodec) ReadResponseHeader(resp *rpc.Response) error {
-	c.response.ResetHeader()
-	data, err := recvFrame(c.r)
+	data, err := c.readResponseFrame()
 	if err != nil {
+		if c.callbacks != nil {
+			c.callbacks.CloseWithError(err)
+		}
 		return err
 	}
+	c.response.ResetHeader()
 	if err = c.response.Unmarshal(data); err != nil {
 		return err
 	}
+	// 先校验整个响应帧，再采用其中的错误、元数据与字典确认
+	if err = c.verifyResponse(); err != nil {
+		return desync(c.stateful, c.conn, err)
+	}
+
+	// 服务端在响应中确认字典后，之后的请求使用该字典压缩
+	if c.dictID != 0 && c.response.GetDictID() == c.dictID {
+		atomic.StoreInt32(&c.dictOK, 1)
+	}
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	resp.Seq = c.response.ID
 	resp.Error = c.response.Error
-	resp.ServiceMethod = c.pending[resp.Seq]
+	pending := c.pending[resp.Seq]
+	resp.ServiceMethod = pending.serviceMethod
+	if pending.call != nil {
+		pending.call.Metadata = c.response.GetMetadata()
+	}
 	delete(c.pending, resp.Seq)
 	return nil
 }
 
-// ReadResponseBody read the rpc response body from the io stream
-func (c *clientCodec) ReadResponseBody(param interface{}) error {
-	if param == nil {
-		if c.response.ResponseLen != 0 {
-			return read(c.r, make([]byte, int(c.response.ResponseLen)))
+func (c *clientCodec) readResponseFrame() ([]byte, error) {
+	for {
+		t, err := recvFrameType(c.r)
+		if err != nil {
+			return nil, err
+		}
+		data, err := recvFrame(c.r)
+		if err != nil {
+			return nil, err
+		}
+		if t == responseFrame {
+			return data, nil
+		}
+		h := header.RequestPool.Get().(*header.RequestHeader)
+		err = h.Unmarshal(data)
+		bodyLen := h.RequestLen
+		h.ResetHeader()
+		header.RequestPool.Put(h)
+		if err != nil {
+			return nil, err
+		}
+		if c.callbacks == nil {
+			return nil, ErrUnexpectedFrame
+		}
+		if c.onCallback != nil {
+			c.notified.Do(c.onCallback)
 		}
+		if err = forwardFrame(c.callbacks, c.r, t, data, bodyLen); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// verifyResponse verifies the checksum of the response whose header was read, the
+// body is read to verify it and kept for ReadResponseBody. net/rpc reports the error
+// of a response before reading its body, so the check can not wait for the body.
+func (c *clientCodec) verifyResponse() error {
+	c.body = nil
+	// 响应必须使用与请求相同的校验算法，防止被降级为不校验
+	if c.response.GetChecksumType() != c.checksum {
+		return ErrChecksumTypeMismatch
+	}
+	if c.checksum == checksum.None {
 		return nil
 	}
-	respBody := make([]byte, int(c.response.ResponseLen))
-	if err := read(c.r, respBody); err != nil {
+	sum, err := checksum.Get(c.checksum, c.checksumKey)
+	if err != nil {
 		return err
 	}
+	body := make([]byte, int(c.response.ResponseLen))
+	if err = read(c.r, body); err != nil {
+		return err
+	}
+	if !verifyFrame(sum, &c.response, body, c.response.Checksum) {
+		return ErrUnexpectedChecksum
+	}
+	c.body = body
+	return nil
+}
+
+// ReadResponseBody read the rpc response body from the io stream. A stateful session
+// can not decode the following responses once a body fails, so the connection is closed.
+func (c *clientCodec) ReadResponseBody(param interface{}) error {
+	return desync(c.stateful, c.conn, c.readResponseBody(param))
+}
 
-	if c.response.Checksum != 0 {
-		if crc32.ChecksumIEEE(respBody) != c.response.Checksum {
-			return ErrUnexpectedChecksum
+func (c *clientCodec) readResponseBody(param interface{}) error {
+	respBody := c.body
+	c.body = nil
+	if respBody == nil { // 未校验的响应体从连接读取
+		if d, ok := c.decoder(param); ok {
+			return decode(c.r, int(c.response.ResponseLen), d, param)
+		}
+		respBody = make([]byte, int(c.response.ResponseLen))
+		if err := read(c.r, respBody); err != nil {
+			return err
 		}
 	}
+	// 有状态的序列化器需要按顺序解码每个响应，即使响应被丢弃
+	if param == nil && (!c.stateful || len(respBody) == 0) {
+		return nil
+	}
 
-	if c.response.GetCompressType() != c.compressor {
+	// 按响应帧自身记录的压缩类型解压，只接受客户端声明过的类型
+	compressType := c.response.GetCompressType()
+	if !accepts(c.accept, compressType) {
 		return ErrCompressorTypeMismatch
 	}
-
-	resp, err := compressor.Compressors[c.compressor].Unzip(respBody)
+	var dictID uint32
+	if c.response.UsesDict() {
+		if dictID = c.response.GetDictID(); dictID == 0 || dictID != c.dictID {
+			return ErrUnexpectedDict
+		}
+	}
+	resp, err := decompress(compressType, dictID, respBody)
 	if err != nil {
 		return err
 	}
-	return c.serializer.Unmarshal(resp, param)
+	return unmarshal(c.serializer, c.stateful, resp, param)
+}
+
+// decoder returns the serializer.Decoder of the serializer if the response body
+// can be decoded from the connection, i.e. it is neither compressed nor checksummed
+func (c *clientCodec) decoder(param interface{}) (serializer.Decoder, bool) {
+	d, ok := c.serializer.(serializer.Decoder)
+	if !ok || param == nil || c.response.ResponseLen == 0 || c.checksum != checksum.None ||
+		c.response.GetChecksumType() != checksum.None ||
+		c.response.GetCompressType() != compressor.Raw || c.response.UsesDict() {
+		return nil, false
+	}
+	if _, raw := param.(*serializer.RawMessage); raw {
+		return nil, false
+	}
+	return d, true
 }
 
 func (c *clientCodec) Close() error {
+	if c.callbacks != nil {
+		c.callbacks.CloseWithError(nil)
+	}
+	if c.ownWriter {
+		c.w.close()
+	}
 	return c.c.Close()
 }
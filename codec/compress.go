@@ -0,0 +1,106 @@
+package codec
+
+import "tinyrpc/compressor"
+
+// lookup returns the compressor of t with the shared dictionary dictID, and the id of
+// the dictionary actually used. t falls back to no dictionary if it doesn't support one.
+func lookup(t compressor.CompressType, dictID uint32) (compressor.Compressor, uint32, error) {
+	if dictID != 0 && t != compressor.Raw {
+		if c, err := compressor.LookupDict(t, dictID); err == nil {
+			return c, dictID, nil
+		}
+	}
+	c, ok := compressor.Lookup(t)
+	if !ok {
+		return nil, 0, ErrNotFoundCompressor
+	}
+	return c, 0, nil
+}
+
+// supportsDict reports whether t compresses with the shared dictionary dictID
+func supportsDict(t compressor.CompressType, dictID uint32) bool {
+	if dictID == 0 || t == compressor.Raw {
+		return false
+	}
+	_, err := compressor.LookupDict(t, dictID)
+	return err == nil
+}
+
+// compress zips data with t and the dictionary dictID (0 means none), it returns the
+// compress type and the dictionary actually used. In auto mode (threshold > 0) bodies
+// shorter than threshold, and bodies that t doesn't shrink, are sent as compressor.Raw.
+func compress(t compressor.CompressType, dictID uint32, threshold int,
+	data []byte) (compressor.CompressType, uint32, []byte, error) {
+	c, dictID, err := lookup(t, dictID)
+	if err != nil {
+		return t, 0, nil, err
+	}
+	if threshold <= 0 || t == compressor.Raw {
+		zipped, err := c.Zip(data)
+		return t, dictID, zipped, err
+	}
+	if len(data) < threshold {
+		return compressor.Raw, 0, data, nil
+	}
+	zipped, err := c.Zip(data)
+	if err != nil {
+		return t, 0, nil, err
+	}
+	if len(zipped) >= len(data) { // 压缩后没有变小
+		return compressor.Raw, 0, data, nil
+	}
+	return t, dictID, zipped, nil
+}
+
+// decompress unzips data according to the compress type and dictionary of its frame
+func decompress(t compressor.CompressType, dictID uint32, data []byte) ([]byte, error) {
+	if dictID != 0 {
+		c, err := compressor.LookupDict(t, dictID)
+		if err != nil {
+			return nil, err
+		}
+		return c.Unzip(data)
+	}
+	c, ok := compressor.Lookup(t)
+	if !ok {
+		return nil, ErrNotFoundCompressor
+	}
+	return c.Unzip(data)
+}
+
+// CompressPolicy chooses the compress type of a response body of size bytes from the
+// types accepted by the client, which are listed in order of preference and never empty.
+// A type that the client doesn't accept falls back to its preferred type.
+type CompressPolicy func(accept []compressor.CompressType, size int) compressor.CompressType
+
+// PreferredCompress is the default CompressPolicy, it uses the type preferred by the client
+func PreferredCompress(accept []compressor.CompressType, size int) compressor.CompressType {
+	return accept[0]
+}
+
+// SizeCompress returns a CompressPolicy that sends bodies shorter than threshold as
+// compressor.Raw and the others with t if the client accepts it
+func SizeCompress(threshold int, t compressor.CompressType) CompressPolicy {
+	return func(accept []compressor.CompressType, size int) compressor.CompressType {
+		if size < threshold {
+			return compressor.Raw
+		}
+		if accepts(accept, t) {
+			return t
+		}
+		return accept[0]
+	}
+}
+
+// accepts reports whether a frame compressed with t can be decoded by the client
+func accepts(accept []compressor.CompressType, t compressor.CompressType) bool {
+	if t == compressor.Raw {
+		return true
+	}
+	for _, a := range accept {
+		if a == t {
+			return true
+		}
+	}
+	return false
+}
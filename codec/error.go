@@ -7,4 +7,7 @@ var (
 	ErrUnexpectedChecksum     = errors.New("unexpected checksum")
 	ErrNotFoundCompressor     = errors.New("not found compressor")
 	ErrCompressorTypeMismatch = errors.New("request and response Compressor type mismatch")
+	ErrChecksumTypeMismatch   = errors.New("unexpected checksum type")
+	ErrUnexpectedFrame        = errors.New("unexpected frame type")
+	ErrUnexpectedDict         = errors.New("unexpected compression dictionary")
 )
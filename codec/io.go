@@ -1,11 +1,105 @@
 package codec
 
 import (
+	"bytes"
 	"encoding/binary"
 	"io"
 	"net"
+	"sync"
 )
 
+// 帧类型，双向连接上两个方向的请求与响应共用一个连接，每帧以 1 字节的类型开头
+const (
+	requestFrame byte = iota
+	responseFrame
+)
+
+// recvFrameType read the type of the next frame
+func recvFrameType(r io.Reader) (byte, error) {
+	return r.(io.ByteReader).ReadByte()
+}
+
+// forwardFrame 读取 bodyLen 长度的帧体，并将整帧交给处理另一方向调用的 codec
+func forwardFrame(w io.Writer, r io.Reader, t byte, data []byte, bodyLen uint32) error {
+	body := make([]byte, int(bodyLen))
+	if err := read(r, body); err != nil {
+		return err
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, 1+binary.MaxVarintLen64+len(data)+len(body)))
+	buf.WriteByte(t)
+	sendFrame(buf, data)
+	buf.Write(body)
+	// 另一方向的 codec 已关闭时丢弃该帧，不影响本方向的调用
+	w.Write(buf.Bytes())
+	return nil
+}
+
+// framePipe 缓冲转发给另一方向 codec 的帧，写入从不阻塞，
+// 因此处理较慢的回调不会阻塞连接的读循环
+type framePipe struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  bytes.Buffer
+	err  error // 关闭后读取完缓冲的数据返回该错误
+}
+
+func newFramePipe() *framePipe {
+	p := &framePipe{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// Write 缓冲 b，读端关闭后返回 io.ErrClosedPipe
+func (p *framePipe) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return 0, io.ErrClosedPipe
+	}
+	p.buf.Write(b)
+	p.cond.Signal()
+	return len(b), nil
+}
+
+// Read 阻塞至有缓冲的数据或管道关闭
+func (p *framePipe) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.buf.Len() == 0 && p.err == nil {
+		p.cond.Wait()
+	}
+	if p.buf.Len() != 0 {
+		return p.buf.Read(b)
+	}
+	return 0, p.err
+}
+
+// CloseWithError 关闭写端，读端读完缓冲的数据后返回 err，err 为 nil 时返回 io.EOF
+func (p *framePipe) CloseWithError(err error) error {
+	if err == nil {
+		err = io.EOF
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.cond.Broadcast()
+	return nil
+}
+
+// Close 关闭读端并丢弃缓冲的数据，此后的写入返回 io.ErrClosedPipe
+func (p *framePipe) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = io.ErrClosedPipe
+	}
+	p.buf.Reset()
+	p.cond.Broadcast()
+	return nil
+}
+
 // sendFrame write requestHeadr or responseHeader
 // 若写入数据的长度为 0 ，此时sendFrame 函数会向IO流写入uvarint类型的 0 值；
 // 若写入数据的长度大于 0 ，此时sendFrame 函数会向IO流写入uvarint类型的 len(data) 值，随后将该字节串的数据 data 写入IO流中。
@@ -0,0 +1,117 @@
+package codec
+
+import (
+	"time"
+	"tinyrpc/checksum"
+	"tinyrpc/compressor"
+)
+
+// Option provides options for codec
+type Option func(o *options)
+
+type options struct {
+	checksumType checksum.Type
+	checksumKey  []byte
+
+	compressThreshold int                       // compress only bodies of at least this size, 0 always compresses
+	accept            []compressor.CompressType // compress types the client accepts for responses
+	compressPolicy    CompressPolicy            // chooses the compress type of each response
+	dictID            uint32                    // shared dictionary proposed by the client
+	sampler           *compressor.Sampler       // captures the payloads of the server
+	metadata          MetadataFunc              // metadata of each request
+
+	writeBatch    bool          // coalesce requests into one flush
+	maxWriteDelay time.Duration // max time a request waits in the write buffer
+	maxWriteBytes int           // write buffer size, a full buffer is flushed at once
+}
+
+// WithChecksum set the checksum algorithm of the request and response body
+func WithChecksum(t checksum.Type) Option {
+	return func(o *options) {
+		o.checksumType = t
+	}
+}
+
+// WithChecksumKey set the shared key of keyed checksums such as HMAC-SHA256.
+// A server codec with a key only accepts HMAC-SHA256 requests.
+func WithChecksumKey(key []byte) Option {
+	return func(o *options) {
+		o.checksumKey = key
+	}
+}
+
+// WithWriteBatch make the client codec flush requests from a background write loop.
+// A request waits at most maxDelay (0 flushes as soon as the loop is scheduled),
+// and the buffer is flushed once maxBytes are queued (0 keeps the default size).
+//
+// Deprecated: the frames waiting for the writer already share one flush, the write
+// loop no longer improves the throughput.
+func WithWriteBatch(maxDelay time.Duration, maxBytes int) Option {
+	return func(o *options) {
+		o.writeBatch = true
+		o.maxWriteDelay = maxDelay
+		o.maxWriteBytes = maxBytes
+	}
+}
+
+// WithCompressThreshold enable the auto compression mode: bodies shorter than threshold
+// and bodies that compression doesn't shrink are sent as compressor.Raw, the compress
+// type actually used is recorded in the header of each frame.
+func WithCompressThreshold(threshold int) Option {
+	return func(o *options) {
+		o.compressThreshold = threshold
+	}
+}
+
+// WithAcceptCompress set the compress types the client accepts for responses in order of
+// preference, compressor.Raw is always accepted. By default only the request compress
+// type is accepted.
+func WithAcceptCompress(types ...compressor.CompressType) Option {
+	return func(o *options) {
+		o.accept = types
+	}
+}
+
+// WithCompressPolicy set how the server chooses the compress type of each response
+func WithCompressPolicy(policy CompressPolicy) Option {
+	return func(o *options) {
+		o.compressPolicy = policy
+	}
+}
+
+// WithDict make the client propose the shared dictionary id registered by
+// compressor.RegisterDict. Requests are compressed with it once the server
+// accepts it in a response, so both sides must have registered it.
+func WithDict(id uint32) Option {
+	return func(o *options) {
+		o.dictID = id
+	}
+}
+
+// WithSampler make the server codec offer the serialized request and response
+// bodies to the sampler, which trains dictionaries from them
+func WithSampler(sampler *compressor.Sampler) Option {
+	return func(o *options) {
+		o.sampler = sampler
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{ // default options config
+		checksumType: checksum.CRC32,
+	}
+	for _, option := range opts {
+		option(&o)
+	}
+	return o
+}
+
+// MetadataFunc returns the metadata of a request to serviceMethod, an error fails the call
+type MetadataFunc func(serviceMethod string) (map[string]string, error)
+
+// WithMetadata set the function that provides the metadata of each request
+func WithMetadata(metadata MetadataFunc) Option {
+	return func(o *options) {
+		o.metadata = metadata
+	}
+}
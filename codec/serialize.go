@@ -0,0 +1,61 @@
+package codec
+
+import (
+	"io"
+	"tinyrpc/serializer"
+)
+
+// marshal encodes message with the serializer of the codec, serializer.RawMessage is
+// already encoded and written as is. Stateful serializers encode it like other messages
+// since the peer decodes every message of the stream.
+// Serializers implementing serializer.Appender or serializer.Encoder encode into buf,
+// the returned body is valid until buf is put back to the pool.
+func marshal(s serializer.Serializer, stateful bool, message interface{}, buf *buffer) ([]byte, error) {
+	if !stateful {
+		switch m := message.(type) {
+		case serializer.RawMessage:
+			return m, nil
+		case *serializer.RawMessage:
+			return *m, nil
+		}
+	}
+	switch e := s.(type) {
+	case serializer.Appender:
+		if sizer, ok := s.(serializer.Sizer); ok {
+			if size := sizer.Size(message); size > cap(buf.b) {
+				buf.b = make([]byte, 0, size)
+			}
+		}
+		var err error
+		buf.b, err = e.MarshalAppend(buf.b[:0], message)
+		return buf.b, err
+	case serializer.Encoder:
+		buf.b = buf.b[:0]
+		err := e.Encode(buf, message)
+		return buf.b, err
+	}
+	return s.Marshal(message)
+}
+
+// unmarshal decodes data into message, *serializer.RawMessage references data as is
+func unmarshal(s serializer.Serializer, stateful bool, data []byte, message interface{}) error {
+	if m, ok := message.(*serializer.RawMessage); ok && !stateful {
+		*m = data
+		return nil
+	}
+	return s.Unmarshal(data, message)
+}
+
+// decode decodes the body of size n from the connection without buffering it,
+// the part of the body left by the decoder is discarded
+func decode(r io.Reader, n int, d serializer.Decoder, message interface{}) error {
+	body := &io.LimitedReader{R: r, N: int64(n)}
+	err := d.Decode(body, message)
+	if _, copyErr := io.Copy(io.Discard, body); copyErr != nil {
+		return copyErr
+	}
+	if body.N > 0 { // 连接提前关闭
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -2,104 +2,273 @@ package codec
 
 import (
 	"bufio"
-	"hash/crc32"
 	"io"
 	"net/rpc"
 	"sync"
+	"tinyrpc/checksum"
 	"tinyrpc/compressor"
 	"tinyrpc/header"
 	"tinyrpc/serializer"
 )
 
 type reqCtx struct {
-	requestID   uint64
-	compareType compressor.CompressType
+	requestID    uint64
+	accept       []compressor.CompressType // compress types accepted by the client
+	dictID       uint32                    // shared dictionary accepted for the response
+	metadata     map[string]string
+	respMetadata map[string]string // metadata of the response
+	checksumType checksum.Type
+	oneWay       bool // the response is dropped
 }
 
 type serverCodec struct {
 	r io.Reader
-	w io.Writer
+	w *connWriter
 	c io.Closer
+	// conn is the connection, closed when the stateful session is out of sync
+	conn io.Closer
 
-	request    header.RequestHeader
-	serializer serializer.Serializer
-	mu         sync.Mutex
-	seq        uint64
-	pending    map[uint64]*reqCtx
+	request     header.RequestHeader
+	serializer  serializer.Serializer
+	stateful    bool // serializer is a session of serializer.Stateful
+	checksumKey []byte
+	threshold   int                 // auto compression threshold
+	policy      CompressPolicy      // chooses the compress type of each response
+	sampler     *compressor.Sampler // captures the payloads to train dictionaries
+	mu          sync.Mutex          // protect seq and pending map
+	seq         uint64
+	pending     map[uint64]*reqCtx
+
+	responses *framePipe // responses of the calls issued by the server are forwarded to peer
+	peer      *clientCodec
 }
 
 // NewServerCodec Create a new server codec
-func NewServerCodec(conn io.ReadWriteCloser, serializer serializer.Serializer) rpc.ServerCodec {
+func NewServerCodec(conn io.ReadWriteCloser, serializer serializer.Serializer, opts ...Option) rpc.ServerCodec {
+	options := newOptions(opts)
+	s := newServerCodec(bufio.NewReader(conn), newConnWriter(conn, options), conn, serializer, options)
+
+	// 服务端发起的调用默认不压缩，配置了密钥时使用 HMAC 校验
+	if len(options.checksumKey) != 0 {
+		options.checksumType = checksum.HMACSHA256
+	}
+	p := newFramePipe()
+	s.responses = p
+	s.peer = newClientCodec(bufio.NewReader(p), s.w, p, compressor.Raw, serializer, options)
+	s.peer.conn = conn
+	return s
+}
+
+func newServerCodec(r io.Reader, w *connWriter, c io.Closer,
+	serializer serializer.Serializer, options options) *serverCodec {
+	serializer, stateful := newSession(serializer)
 	return &serverCodec{
-		r:          bufio.NewReader(conn),
-		w:          bufio.NewWriter(conn),
-		c:          conn,
-		serializer: serializer,
-		pending:    make(map[uint64]*reqCtx),
+		r:           r,
+		w:           w,
+		c:           c,
+		conn:        c,
+		serializer:  serializer,
+		stateful:    stateful,
+		checksumKey: options.checksumKey,
+		threshold:   options.compressThreshold,
+		policy:      options.compressPolicy,
+		sampler:     options.sampler,
+		pending:     make(map[uint64]*reqCtx),
+	}
+}
+
+// Peer is implemented by the tinyrpc server codec, the returned codec issues calls
+// to the client over the same connection.
+type Peer interface {
+	PeerCodec() rpc.ClientCodec
+}
+
+// PeerCodec returns the client codec of the calls issued by the server
+func (s *serverCodec) PeerCodec() rpc.ClientCodec {
+	return s.peer
+}
+
+// RequestMetadata is implemented by the tinyrpc server codec, it returns the metadata
+// of the request seq read by ReadRequestHeader, until the response is written
+type RequestMetadata interface {
+	RequestMetadata(seq uint64) map[string]string
+}
+
+// RequestMetadata returns the metadata of the request seq
+func (s *serverCodec) RequestMetadata(seq uint64) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.pending[seq]; ok {
+		return r.metadata
+	}
+	return nil
+}
+
+// ResponseMetadata is implemented by the tinyrpc server codec, the metadata set
+// before WriteResponse is sent in the response header
+type ResponseMetadata interface {
+	SetResponseMetadata(seq uint64, md map[string]string)
+}
+
+// SetResponseMetadata sets the metadata of the response to the request seq
+func (s *serverCodec) SetResponseMetadata(seq uint64, md map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.pending[seq]; ok {
+		r.respMetadata = md
 	}
 }
 
-// ReadRequestHeader read the rpc request header from the io stream
+// ReadRequestHeader read the rpc request header from the io stream,
+// responses of the calls issued by the server are forwarded to the peer codec
 func (s *serverCodec) ReadRequestHeader(r *rpc.Request) error {
-	s.request.ResetHeader()
-	data, err := recvFrame(s.r)
+	data, err := s.readRequestFrame()
 	if err != nil {
-		return nil
+		if s.responses != nil {
+			s.responses.CloseWithError(err)
+		}
+		return err
 	}
+	s.request.ResetHeader()
 	err = s.request.Unmarshal(data)
 	if err != nil {
 		return err
 	}
+	// 无法按请求的算法计算响应的校验值时关闭连接，而不是不加校验地响应
+	if _, err = checksum.Get(s.request.GetChecksumType(), s.checksumKey); err != nil {
+		return err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	accept := s.request.GetAccept()
+	if len(accept) == 0 { // 未声明时与请求使用相同的压缩类型
+		accept = []compressor.CompressType{s.request.GetCompressType()}
+	}
+	var dictID uint32 // 只接受本端也注册过的字典
+	if id := s.request.GetDictID(); id != 0 && compressor.HasDict(id) {
+		dictID = id
+	}
 	s.seq++
 	s.pending[s.seq] = &reqCtx{
-		requestID:   s.request.ID,
-		compareType: s.request.GetCompressType(),
+		requestID:    s.request.ID,
+		accept:       accept,
+		dictID:       dictID,
+		metadata:     s.request.GetMetadata(),
+		checksumType: s.request.GetChecksumType(),
+		oneWay:       s.request.IsOneWay(),
 	}
 	r.ServiceMethod = s.request.GetMethod()
 	r.Seq = s.seq // response 时会用到
 	return nil
 }
 
-// ReadRequestBody read the rpc request body from the io stream
+func (s *serverCodec) readRequestFrame() ([]byte, error) {
+	for {
+		t, err := recvFrameType(s.r)
+		if err != nil {
+			return nil, err
+		}
+		data, err := recvFrame(s.r)
+		if err != nil {
+			return nil, err
+		}
+		if t == requestFrame {
+			return data, nil
+		}
+		h := header.ResponsePool.Get().(*header.ResponseHeader)
+		err = h.Unmarshal(data)
+		bodyLen := h.ResponseLen
+		h.ResetHeader()
+		header.ResponsePool.Put(h)
+		if err != nil {
+			return nil, err
+		}
+		if s.responses == nil {
+			return nil, ErrUnexpectedFrame
+		}
+		if err = forwardFrame(s.responses, s.r, t, data, bodyLen); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ReadRequestBody read the rpc request body from the io stream. A stateful session
+// can not decode the following requests once a body fails, so the connection is closed.
 func (s *serverCodec) ReadRequestBody(param interface{}) error {
-	if param == nil {
-		if s.request.RequestLen != 0 {
-			// 也需要读出来
-			if err := read(s.r, make([]byte, int(s.request.RequestLen))); err != nil {
-				return err
+	return desync(s.stateful, s.conn, s.readRequestBody(param))
+}
+
+func (s *serverCodec) readRequestBody(param interface{}) error {
+	if d, ok := s.decoder(param); ok {
+		return decode(s.r, int(s.request.RequestLen), d, param)
+	}
+	// 丢弃的请求只跳过请求体，不校验、不解压也不解码
+	if param == nil && !s.stateful {
+		if _, err := io.CopyN(io.Discard, s.r, int64(s.request.RequestLen)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
 			}
+			return err
 		}
 		return nil
 	}
-
 	reqBody := make([]byte, int(s.request.RequestLen))
 	err := read(s.r, reqBody)
 	if err != nil {
 		return err
 	}
+	// 有状态的序列化器需要按顺序解码每个请求，即使请求被丢弃
+	if param == nil && len(reqBody) == 0 {
+		return nil
+	}
 
-	if s.request.Checksum != 0 {
-		if crc32.ChecksumIEEE(reqBody) != s.request.Checksum {
+	checksumType := s.request.GetChecksumType()
+	// 配置了密钥的服务端只接受 HMAC 校验的请求
+	if len(s.checksumKey) != 0 && checksumType != checksum.HMACSHA256 {
+		return ErrChecksumTypeMismatch
+	}
+	if checksumType != checksum.None {
+		sum, err := checksum.Get(checksumType, s.checksumKey)
+		if err != nil {
+			return err
+		}
+		if !verifyFrame(sum, &s.request, reqBody, s.request.Checksum) {
 			return ErrUnexpectedChecksum
 		}
 	}
 
-	c, ok := compressor.Compressors[s.request.GetCompressType()]
-	if !ok {
-		return ErrNotFoundCompressor
+	var dictID uint32
+	if s.request.UsesDict() {
+		dictID = s.request.GetDictID()
 	}
-
-	req, err := c.Unzip(reqBody) // 解压缩
+	req, err := decompress(s.request.GetCompressType(), dictID, reqBody) // 解压缩
 	if err != nil {
 		return err
 	}
+	if s.sampler != nil {
+		s.sampler.Add(req)
+	}
+
+	return unmarshal(s.serializer, s.stateful, req, param) // 反序列化
+}
 
-	return s.serializer.Unmarshal(req, param) // 反序列化
+// decoder returns the serializer.Decoder of the serializer if the request body
+// can be decoded from the connection, i.e. it is neither compressed nor checksummed
+func (s *serverCodec) decoder(param interface{}) (serializer.Decoder, bool) {
+	d, ok := s.serializer.(serializer.Decoder)
+	if !ok || param == nil || s.request.RequestLen == 0 || len(s.checksumKey) != 0 ||
+		s.request.GetChecksumType() != checksum.None ||
+		s.request.GetCompressType() != compressor.Raw || s.request.UsesDict() || s.sampler != nil {
+		return nil, false
+	}
+	if _, raw := param.(*serializer.RawMessage); raw {
+		return nil, false
+	}
+	return d, true
 }
 
-// WriteResponse Write the rpc response header and body to the io stream
+// WriteResponse Write the rpc response header and body to the io stream.
+// It is safe to call concurrently, responses that are ready at the same time share one Flush.
 func (s *serverCodec) WriteResponse(resp *rpc.Response, param interface{}) error {
 	s.mu.Lock()
 	reqCtx, ok := s.pending[resp.Seq]
@@ -110,30 +279,44 @@ func (s *serverCodec) WriteResponse(resp *rpc.Response, param interface{}) error
 	delete(s.pending, resp.Seq)
 	s.mu.Unlock()
 
+	if reqCtx.oneWay { // 单向请求不需要响应
+		return nil
+	}
+
 	if resp.Error != "" { // 如果RPC调用结果有误，把param置为nil
 		param = nil
 	}
 
-	c, ok := compressor.Compressors[reqCtx.compareType]
-	if !ok {
-		return ErrNotFoundCompressor
+	buf := getBuffer() // 写出后放回缓冲池
+	defer putBuffer(buf)
+	return s.w.writeBuiltFrame(responseFrame, s.stateful, func() ([]byte, []byte, error) {
+		return s.buildResponse(reqCtx, resp.Error, param, buf)
+	})
+}
+
+func (s *serverCodec) buildResponse(reqCtx *reqCtx, errmsg string, param interface{},
+	buf *buffer) ([]byte, []byte, error) {
+	sum, err := checksum.Get(reqCtx.checksumType, s.checksumKey)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	var (
-		respBody           []byte // marshal
-		compressedRespBody []byte // zip
-		err                error
-	)
+	var respBody []byte // marshal
 	if param != nil {
-		respBody, err = s.serializer.Marshal(param) // 序列化
+		respBody, err = marshal(s.serializer, s.stateful, param, buf) // 序列化
 		if err != nil {
-			return err
+			return nil, nil, desync(s.stateful, s.conn, err)
 		}
 	}
 
-	compressedRespBody, err = c.Zip(respBody) // 压缩
+	preferred := s.compressType(reqCtx.accept, len(respBody))
+	if s.sampler != nil {
+		s.sampler.Add(respBody)
+	}
+	compressType, dictID, compressedRespBody, err := compress(preferred,
+		reqCtx.dictID, s.threshold, respBody) // 压缩
 	if err != nil {
-		return err
+		return nil, nil, desync(s.stateful, s.conn, err)
 	}
 	h := header.ResponsePool.Get().(*header.ResponseHeader)
 	defer func() {
@@ -141,23 +324,37 @@ func (s *serverCodec) WriteResponse(resp *rpc.Response, param interface{}) error
 		header.ResponsePool.Put(h)
 	}()
 	h.ID = reqCtx.requestID
-	h.Error = resp.Error
+	h.Error = errmsg
 	h.ResponseLen = uint32(len(compressedRespBody))
-	h.Checksum = crc32.ChecksumIEEE(compressedRespBody)
-	h.CompressType = reqCtx.compareType
-	// 发送响应头
-	if err = sendFrame(s.w, h.Marshal()); err != nil {
-		return err
+	h.ChecksumType = sum.Type()
+	h.CompressType = compressType
+	if supportsDict(preferred, reqCtx.dictID) { // 只为能使用字典的压缩类型确认字典
+		h.DictID = reqCtx.dictID
 	}
-	// 发送响应体
-	if err = write(s.w, compressedRespBody); err != nil {
-		return err
+	h.Metadata = reqCtx.respMetadata
+	if dictID != 0 {
+		h.Flags = header.FlagDict
 	}
-	s.w.(*bufio.Writer).Flush()
-	return nil
+	h.Checksum = sumFrame(sum, h, compressedRespBody)
+
+	return h.Marshal(), compressedRespBody, nil
+}
+
+// compressType chooses the compress type of a response body by the policy
+func (s *serverCodec) compressType(accept []compressor.CompressType, size int) compressor.CompressType {
+	if s.policy == nil {
+		return PreferredCompress(accept, size)
+	}
+	if t := s.policy(accept, size); accepts(accept, t) {
+		return t
+	}
+	return accept[0]
 }
 
 // Close can be called multiple times and must be idempotent.
 func (s *serverCodec) Close() error {
+	if s.responses != nil {
+		s.responses.CloseWithError(nil)
+	}
 	return s.c.Close()
 }
@@ -0,0 +1,25 @@
+package codec
+
+import (
+	"io"
+	"tinyrpc/serializer"
+)
+
+// newSession returns a new session of a stateful serializer, other serializers are
+// shared by all codecs and returned as is
+func newSession(s serializer.Serializer) (serializer.Serializer, bool) {
+	if st, ok := s.(serializer.Stateful); ok {
+		return st.NewSession(), true
+	}
+	return s, false
+}
+
+// desync closes conn when err leaves the session of a stateful serializer out of sync
+// with the peer, i.e. a body failed after it was encoded or before it was decoded.
+// The session of the peer can not be reset from this side, so the connection is dropped.
+func desync(stateful bool, conn io.Closer, err error) error {
+	if stateful && err != nil {
+		conn.Close()
+	}
+	return err
+}
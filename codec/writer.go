@@ -0,0 +1,131 @@
+package codec
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connWriter 连接的写端，双向连接上请求与响应两个方向的 codec 共享同一个写端。
+// 同时等待写入的帧合并为一次 Flush，开启批量写时由后台 writeLoop 负责 Flush。
+type connWriter struct {
+	mu       sync.Mutex // protect w and err
+	w        *bufio.Writer
+	err      error         // error of the background flush, returned by the following writes
+	writers  int32         // number of frames waiting to be written
+	flushc   chan struct{} // notify the write loop, nil if the last waiting frame flushes
+	maxDelay time.Duration
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newConnWriter(w io.Writer, options options) *connWriter {
+	cw := &connWriter{
+		w:    bufio.NewWriter(w),
+		done: make(chan struct{}),
+	}
+	if options.writeBatch {
+		if options.maxWriteBytes > 0 {
+			cw.w = bufio.NewWriterSize(w, options.maxWriteBytes)
+		}
+		cw.flushc = make(chan struct{}, 1)
+		cw.maxDelay = options.maxWriteDelay
+		go cw.writeLoop()
+	}
+	return cw
+}
+
+// writeFrame write the frame type, header and body, it is safe to call concurrently
+func (cw *connWriter) writeFrame(t byte, header []byte, body []byte) error {
+	return cw.writeLocked(t, func() ([]byte, []byte, error) {
+		return header, body, nil
+	})
+}
+
+// buildFrame returns the header and body of a frame
+type buildFrame func() (header []byte, body []byte, err error)
+
+// writeBuiltFrame build the frame and write it. If ordered is set the frame is built
+// under the write lock, so frames are built in the same order as they are written,
+// which stateful serializers rely on.
+func (cw *connWriter) writeBuiltFrame(t byte, ordered bool, build buildFrame) error {
+	if ordered {
+		return cw.writeLocked(t, build)
+	}
+	header, body, err := build()
+	if err != nil {
+		return err
+	}
+	return cw.writeFrame(t, header, body)
+}
+
+func (cw *connWriter) writeLocked(t byte, build buildFrame) error {
+	atomic.AddInt32(&cw.writers, 1)
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	var header, body []byte
+	err := cw.err
+	if err == nil {
+		header, body, err = build()
+	}
+	if err == nil {
+		err = write(cw.w, []byte{t})
+	}
+	if err == nil {
+		err = sendFrame(cw.w, header)
+	}
+	if err == nil {
+		err = write(cw.w, body)
+	}
+	if cw.flushc != nil {
+		select { // 由 writeLoop 合并刷新
+		case cw.flushc <- struct{}{}:
+		default:
+		}
+		atomic.AddInt32(&cw.writers, -1)
+		return err
+	}
+	// 最后一个等待写入的帧负责 Flush
+	if atomic.AddInt32(&cw.writers, -1) == 0 {
+		if flushErr := cw.w.Flush(); err == nil {
+			err = flushErr
+		}
+	}
+	return err
+}
+
+// writeLoop flushes the frames buffered since the last flush
+func (cw *connWriter) writeLoop() {
+	for {
+		select {
+		case <-cw.done:
+			return
+		case <-cw.flushc:
+		}
+		if cw.maxDelay > 0 { // 等待更多请求进入缓冲区
+			timer := time.NewTimer(cw.maxDelay)
+			select {
+			case <-timer.C:
+			case <-cw.done:
+				timer.Stop()
+			}
+		}
+		cw.mu.Lock()
+		if err := cw.w.Flush(); err != nil && cw.err == nil {
+			cw.err = err
+		}
+		cw.mu.Unlock()
+	}
+}
+
+// close stops the write loop and flushes the buffered frames
+func (cw *connWriter) close() {
+	cw.once.Do(func() {
+		close(cw.done)
+		cw.mu.Lock()
+		cw.w.Flush()
+		cw.mu.Unlock()
+	})
+}
@@ -0,0 +1,54 @@
+package compressor
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+// MaxDecodedSize 解压后数据的最大长度，避免少量压缩数据解压出过大的内容
+const MaxDecodedSize = 64 << 20
+
+var ErrDecodedTooLarge = errors.New("decoded data is too large")
+
+// maxPooledBuffer 超过该大小的缓冲区不放回缓冲池，避免个别大消息长期占用内存
+const maxPooledBuffer = 64 << 10
+
+// bufferPool 压缩与解压时复用的缓冲区
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// copyBytes 返回 buf 内容的副本，使 buf 可以放回缓冲池
+func copyBytes(buf *bytes.Buffer) []byte {
+	data := make([]byte, buf.Len())
+	copy(data, buf.Bytes())
+	return data
+}
+
+// readDecoded 将 r 解压出的数据读入 buf，超过 MaxDecodedSize 时返回 ErrDecodedTooLarge
+func readDecoded(buf *bytes.Buffer, r io.Reader) error {
+	n, err := buf.ReadFrom(io.LimitReader(r, MaxDecodedSize+1))
+	if err != nil {
+		return err
+	}
+	if n > MaxDecodedSize {
+		return ErrDecodedTooLarge
+	}
+	return nil
+}
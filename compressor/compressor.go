@@ -1,5 +1,11 @@
 package compressor
 
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
 type CompressType uint16
 
 const (
@@ -7,8 +13,28 @@ const (
 	Gzip
 	Snappy
 	Zlib
+	Zstd
+	LZ4
+)
+
+// UserDefined CompressType values from UserDefined on are left to custom compressors,
+// the values below it are reserved for the compressors of tinyrpc
+const UserDefined CompressType = 128
+
+// DefaultLevel lets the compressor use its default compression level
+const DefaultLevel = -1
+
+var (
+	ErrCompressTypeExists = errors.New("compress type already registered")
+	ErrCompressNameExists = errors.New("compressor name already registered")
+	ErrNotFoundCompressor = errors.New("not found compressor")
 )
 
+// Compressors the compressors of the registered types used by codecs, see Configure.
+// Compressors added to it directly are still used by codecs for unregistered types.
+//
+// Deprecated: use Register, Lookup and Configure instead, the map is not safe for
+// concurrent writes.
 var Compressors = map[CompressType]Compressor{}
 
 // Compressor 对函数传递参数进行压缩和解压缩
@@ -16,3 +42,175 @@ type Compressor interface {
 	Zip([]byte) ([]byte, error)
 	Unzip([]byte) ([]byte, error)
 }
+
+// Options compressor options, passed to the Factory
+type Options struct {
+	Level  int    // compression level, the meaning depends on the compressor
+	DictID uint32 // id of Dict, 0 means no dictionary
+	Dict   []byte // shared dictionary, ignored by compressors without DictCompressor
+}
+
+// Option provides options for compressor
+type Option func(o *Options)
+
+// WithLevel set compression level, it is ignored by compressors without levels
+func WithLevel(level int) Option {
+	return func(o *Options) {
+		o.Level = level
+	}
+}
+
+// WithDict set the shared dictionary and its id, see RegisterDict
+func WithDict(id uint32, dict []byte) Option {
+	return func(o *Options) {
+		o.DictID = id
+		o.Dict = dict
+	}
+}
+
+// Factory creates a compressor with the options
+type Factory func(o Options) (Compressor, error)
+
+type registration struct {
+	name       string
+	factory    Factory
+	compressor Compressor // used by codecs
+}
+
+var registry = struct {
+	sync.RWMutex
+	types map[CompressType]*registration
+	names map[string]CompressType
+}{
+	types: make(map[CompressType]*registration),
+	names: make(map[string]CompressType),
+}
+
+// Register registers the compressor factory of t with a unique name, the compressor
+// used by codecs is created with the default options. It fails if t or name is taken.
+func Register(t CompressType, name string, factory Factory) error {
+	registry.RLock()
+	err := checkRegister(t, name)
+	registry.RUnlock()
+	if err != nil {
+		return err
+	}
+	// factory 不持有锁调用，允许其查找其他压缩器
+	c, err := factory(newOptions(nil))
+	if err != nil {
+		return err
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if err = checkRegister(t, name); err != nil {
+		return err
+	}
+	registry.types[t] = &registration{name: name, factory: factory, compressor: c}
+	registry.names[name] = t
+	Compressors[t] = c
+	return nil
+}
+
+// checkRegister reports whether t or name is taken, registry must be locked
+func checkRegister(t CompressType, name string) error {
+	if r, ok := registry.types[t]; ok {
+		return fmt.Errorf("%w: %d is %q", ErrCompressTypeExists, t, r.name)
+	}
+	if _, ok := registry.names[name]; ok {
+		return fmt.Errorf("%w: %q", ErrCompressNameExists, name)
+	}
+	return nil
+}
+
+// Unregister removes the compressor of t, so t and its name can be registered again
+func Unregister(t CompressType) error {
+	registry.Lock()
+	r, ok := registry.types[t]
+	if !ok {
+		registry.Unlock()
+		return ErrNotFoundCompressor
+	}
+	delete(registry.types, t)
+	delete(registry.names, r.name)
+	delete(Compressors, t)
+	registry.Unlock()
+	dropDictCompressors(t)
+	return nil
+}
+
+// mustRegister registers the builtin compressors
+func mustRegister(t CompressType, name string, factory Factory) {
+	if err := Register(t, name, factory); err != nil {
+		panic(err)
+	}
+}
+
+// Lookup returns the compressor of t used by codecs
+func Lookup(t CompressType) (Compressor, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	r, ok := registry.types[t]
+	if !ok {
+		c, ok := Compressors[t]
+		return c, ok
+	}
+	return r.compressor, true
+}
+
+// LookupByName returns the type and compressor registered with name
+func LookupByName(name string) (CompressType, Compressor, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	t, ok := registry.names[name]
+	if !ok {
+		return 0, nil, false
+	}
+	return t, registry.types[t].compressor, true
+}
+
+// New creates a new compressor of t with the options
+func New(t CompressType, opts ...Option) (Compressor, error) {
+	registry.RLock()
+	r, ok := registry.types[t]
+	registry.RUnlock()
+	if !ok {
+		return nil, ErrNotFoundCompressor
+	}
+	return r.factory(newOptions(opts))
+}
+
+// Configure replaces the compressor of t used by codecs with one created with the options,
+// e.g. Configure(Gzip, WithLevel(gzip.BestSpeed))
+func Configure(t CompressType, opts ...Option) error {
+	c, err := New(t, opts...)
+	if err != nil {
+		return err
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	r, ok := registry.types[t] // 可能已被 Unregister
+	if !ok {
+		return ErrNotFoundCompressor
+	}
+	r.compressor = c
+	Compressors[t] = c
+	return nil
+}
+
+// String returns the registered name of t
+func (t CompressType) String() string {
+	registry.RLock()
+	defer registry.RUnlock()
+	if r, ok := registry.types[t]; ok {
+		return r.name
+	}
+	return fmt.Sprintf("CompressType(%d)", uint16(t))
+}
+
+func newOptions(opts []Option) Options {
+	o := Options{Level: DefaultLevel}
+	for _, option := range opts {
+		option(&o)
+	}
+	return o
+}
@@ -0,0 +1,453 @@
+package compressor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	// arithRequest ArithRequest{A: 1, B: 2} encoded by proto
+	arithRequest = []byte{0x9, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0xf0,
+		0x3f, 0x11, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x40}
+	// arithResponse ArithResponse{C: 3} encoded by proto
+	arithResponse = []byte{0x9, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x8, 0x40}
+	// incompressible payload that lz4 stores as is
+	randomPayload = func() []byte {
+		data := make([]byte, 1024)
+		rand.New(rand.NewSource(1)).Read(data)
+		return data
+	}()
+	// largePayload multi-KB json payload
+	largePayload = func() []byte {
+		buf := bytes.NewBuffer(nil)
+		buf.WriteString("[")
+		for i := 0; i < 100; i++ {
+			fmt.Fprintf(buf, `{"id":%d,"method":"ArithService.Add","a":%d,"b":%d,"c":%d},`, i, i*3, i*7, i*10)
+		}
+		buf.WriteString("{}]")
+		return buf.Bytes()
+	}()
+)
+
+var payloads = []struct {
+	name string
+	data []byte
+}{
+	{"empty", []byte{}},
+	{"arith-request", arithRequest},
+	{"arith-response", arithResponse},
+	{"large", largePayload},
+	{"random", randomPayload},
+}
+
+var compressTypes = []struct {
+	name string
+	t    CompressType
+}{
+	{"raw", Raw},
+	{"gzip", Gzip},
+	{"snappy", Snappy},
+	{"zlib", Zlib},
+	{"zstd", Zstd},
+	{"lz4", LZ4},
+}
+
+func TestCompressor_RoundTrip(t *testing.T) {
+	for _, ct := range compressTypes {
+		for _, p := range payloads {
+			t.Run(ct.name+"-"+p.name, func(t *testing.T) {
+				c, ok := Lookup(ct.t)
+				assert.Equal(t, true, ok)
+				zipped, err := c.Zip(p.data)
+				assert.Equal(t, nil, err)
+				unzipped, err := c.Unzip(zipped)
+				assert.Equal(t, nil, err)
+				assert.Equal(t, true, bytes.Equal(p.data, unzipped))
+			})
+		}
+	}
+}
+
+// reverseCompressor a custom compressor that reverses the data
+type reverseCompressor struct{}
+
+func (reverseCompressor) Zip(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i := range data {
+		out[len(data)-1-i] = data[i]
+	}
+	return out, nil
+}
+
+func (c reverseCompressor) Unzip(data []byte) ([]byte, error) {
+	return c.Zip(data)
+}
+
+func TestRegister(t *testing.T) {
+	t.Cleanup(func() { Unregister(UserDefined) })
+	calls := 0
+	factory := func(Options) (Compressor, error) {
+		calls++
+		return reverseCompressor{}, nil
+	}
+	cases := []struct {
+		name  string
+		t     CompressType
+		cname string
+		err   error
+	}{
+		{"test-register", UserDefined, "reverse", nil},
+		{"test-type-exists", Gzip, "reverse-gzip", ErrCompressTypeExists},
+		{"test-name-exists", UserDefined + 1, "gzip", ErrCompressNameExists},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := Register(c.t, c.cname, factory)
+			assert.Equal(t, true, errors.Is(err, c.err))
+		})
+	}
+
+	ct, c, ok := LookupByName("reverse")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, UserDefined, ct)
+	assert.Equal(t, "reverse", ct.String())
+	data, _ := c.Zip([]byte("abc"))
+	assert.Equal(t, []byte("cba"), data)
+
+	// the factory is not called for taken types or names
+	assert.Equal(t, 1, calls)
+
+	_, ok = Lookup(UserDefined + 1)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, "CompressType(129)", (UserDefined + 1).String())
+	assert.Equal(t, "gzip", Gzip.String())
+}
+
+func TestUnregister(t *testing.T) {
+	factory := func(Options) (Compressor, error) { return reverseCompressor{}, nil }
+	assert.Equal(t, nil, Register(UserDefined+2, "reverse-2", factory))
+	assert.Equal(t, reverseCompressor{}, Compressors[UserDefined+2])
+
+	assert.Equal(t, nil, Unregister(UserDefined+2))
+	_, ok := Lookup(UserDefined + 2)
+	assert.Equal(t, false, ok)
+	_, _, ok = LookupByName("reverse-2")
+	assert.Equal(t, false, ok)
+	assert.Equal(t, ErrNotFoundCompressor, Unregister(UserDefined+2))
+
+	// compressors added to the deprecated map are still found
+	Compressors[UserDefined+2] = reverseCompressor{}
+	t.Cleanup(func() { delete(Compressors, UserDefined+2) })
+	c, ok := Lookup(UserDefined + 2)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, reverseCompressor{}, c)
+}
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		name  string
+		t     CompressType
+		level int
+		err   bool
+	}{
+		{"test-gzip-best-speed", Gzip, gzip.BestSpeed, false},
+		{"test-gzip-invalid-level", Gzip, 10, true},
+		{"test-zlib-best-compression", Zlib, zlib.BestCompression, false},
+		{"test-zlib-invalid-level", Zlib, -3, true},
+		{"test-zstd-best", Zstd, 19, false},
+		{"test-snappy-ignores-level", Snappy, 100, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cpr, err := New(c.t, WithLevel(c.level))
+			assert.Equal(t, c.err, err != nil)
+			if err != nil {
+				return
+			}
+			zipped, err := cpr.Zip(largePayload)
+			assert.Equal(t, nil, err)
+			data, err := cpr.Unzip(zipped)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, largePayload, data)
+		})
+	}
+
+	_, err := New(UserDefined + 2)
+	assert.Equal(t, ErrNotFoundCompressor, err)
+}
+
+func TestConfigure(t *testing.T) {
+	defaultGzip, _ := Lookup(Gzip)
+	defer Configure(Gzip)
+
+	assert.Equal(t, nil, Configure(Gzip, WithLevel(gzip.NoCompression)))
+	c, _ := Lookup(Gzip)
+	assert.Equal(t, c, Compressors[Gzip])
+	zipped, err := c.Zip(largePayload)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, len(zipped) > len(largePayload))
+
+	// compressed data can be decoded regardless of the level
+	data, err := defaultGzip.Unzip(zipped)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, largePayload, data)
+
+	assert.NotEqual(t, nil, Configure(Gzip, WithLevel(42)))
+
+	// 创建压缩器期间被 Unregister 的类型不再配置
+	ct := UserDefined + 3
+	assert.Equal(t, nil, Register(ct, "unregistering", func(o Options) (Compressor, error) {
+		if o.Level == 1 {
+			Unregister(ct)
+		}
+		return reverseCompressor{}, nil
+	}))
+	t.Cleanup(func() { Unregister(ct) })
+	assert.Equal(t, ErrNotFoundCompressor, Configure(ct, WithLevel(1)))
+	_, ok := Compressors[ct]
+	assert.Equal(t, false, ok)
+}
+
+func TestZstdCompressor_Level(t *testing.T) {
+	for _, level := range []int{1, ZstdDefaultLevel, 7, 19} {
+		zipped, err := NewZstdCompressor(level).Zip(largePayload)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, len(zipped) < len(largePayload))
+
+		// any level can be decoded by the registered compressor
+		c, _ := Lookup(Zstd)
+		data, err := c.Unzip(zipped)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, largePayload, data)
+	}
+}
+
+func TestZstdCompressor_Unzip(t *testing.T) {
+	c, _ := Lookup(Zstd)
+	bomb := make([]byte, ZstdMaxDecodedSize+1)
+	zipped, err := c.Zip(bomb)
+	assert.Equal(t, nil, err)
+	_, err = c.Unzip(zipped)
+	assert.ErrorIs(t, err, zstd.ErrDecoderSizeExceeded)
+}
+
+func TestCompressor_UnzipTooLarge(t *testing.T) {
+	bomb := make([]byte, MaxDecodedSize+1)
+	for _, ct := range []CompressType{Gzip, Zlib, Snappy} {
+		t.Run(ct.String(), func(t *testing.T) {
+			c, _ := Lookup(ct)
+			zipped, err := c.Zip(bomb)
+			assert.Equal(t, nil, err)
+			_, err = c.Unzip(zipped)
+			assert.Equal(t, ErrDecodedTooLarge, err)
+		})
+	}
+}
+
+func TestLZ4Compressor_Unzip(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"test-invalid-mode", []byte{0x9, 0x1, 0x0}, ErrInvalidLZ4Block},
+		{"test-stored-length-mismatch", []byte{lz4Stored, 0x2, 0x0}, ErrInvalidLZ4Block},
+		{"test-block-length-too-large", []byte{lz4Block, 0xff, 0xff, 0x3, 0x0}, ErrInvalidLZ4Block},
+		{"test-truncated-length", []byte{lz4Block}, ErrInvalidLZ4Block},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			lz4, _ := Lookup(LZ4)
+			_, err := lz4.Unzip(c.data)
+			assert.Equal(t, c.err, err)
+		})
+	}
+}
+
+func TestCompressor_Concurrent(t *testing.T) {
+	for _, ct := range compressTypes {
+		t.Run(ct.name, func(t *testing.T) {
+			c, _ := Lookup(ct.t)
+			wg := new(sync.WaitGroup)
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for _, p := range payloads {
+						zipped, err := c.Zip(p.data)
+						assert.Equal(t, nil, err)
+						data, err := c.Unzip(zipped)
+						assert.Equal(t, nil, err)
+						assert.Equal(t, true, bytes.Equal(p.data, data))
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestRegisterDict(t *testing.T) {
+	assert.Equal(t, ErrInvalidDictID, RegisterDict(0, []byte("dict")))
+	assert.Equal(t, ErrEmptyDict, RegisterDict(100, nil))
+	assert.Equal(t, nil, RegisterDict(100, []byte("dict")))
+	t.Cleanup(func() { UnregisterDict(100) })
+	assert.Equal(t, ErrDictExists, RegisterDict(100, []byte("other")))
+	assert.Equal(t, true, HasDict(100))
+	assert.Equal(t, false, HasDict(101))
+}
+
+func TestUnregisterDict(t *testing.T) {
+	assert.Equal(t, nil, RegisterDict(101, []byte("dict")))
+	_, err := LookupDict(Zlib, 101)
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, nil, UnregisterDict(101))
+	assert.Equal(t, false, HasDict(101))
+	_, err = LookupDict(Zlib, 101)
+	assert.Equal(t, ErrNotFoundDict, err)
+	assert.Equal(t, ErrNotFoundDict, UnregisterDict(101))
+}
+
+func TestLookupDict(t *testing.T) {
+	samples := make([][]byte, 0, 100)
+	for i := 0; i < 100; i++ {
+		samples = append(samples,
+			[]byte(fmt.Sprintf(`{"id":%d,"method":"ArithService.Add","a":%d,"b":%d}`, i, i*3, i*7)))
+	}
+	dict, err := TrainDict(samples, 1024)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, RegisterDict(200, dict))
+	t.Cleanup(func() { UnregisterDict(200) })
+
+	message := []byte(`{"id":1000,"method":"ArithService.Add","a":3000,"b":7000}`)
+	cases := []struct {
+		name string
+		t    CompressType
+		err  error
+	}{
+		{"test-zlib", Zlib, nil},
+		{"test-zstd", Zstd, nil},
+		{"test-gzip", Gzip, ErrDictNotSupported},
+		{"test-raw", Raw, ErrDictNotSupported},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dc, err := LookupDict(c.t, 200)
+			assert.Equal(t, c.err, err)
+			if err != nil {
+				return
+			}
+			zipped, err := dc.Zip(message)
+			assert.Equal(t, nil, err)
+			data, err := dc.Unzip(zipped)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, message, data)
+
+			// 小消息使用字典后应明显小于不使用字典
+			plain, _ := Lookup(c.t)
+			plainZipped, _ := plain.Zip(message)
+			assert.Less(t, len(zipped), len(plainZipped))
+			assert.Less(t, len(zipped), len(message))
+
+			cached, _ := LookupDict(c.t, 200)
+			assert.Equal(t, dc, cached)
+		})
+	}
+	_, err = LookupDict(Zlib, 201)
+	assert.Equal(t, ErrNotFoundDict, err)
+
+	// 不支持字典的结果被缓存，不会每次重新创建压缩器
+	created := 0
+	ct := UserDefined + 4
+	assert.Equal(t, nil, Register(ct, "no-dict", func(Options) (Compressor, error) {
+		created++
+		return reverseCompressor{}, nil
+	}))
+	t.Cleanup(func() { Unregister(ct) })
+	for i := 0; i < 3; i++ {
+		_, err = LookupDict(ct, 200)
+		assert.Equal(t, ErrDictNotSupported, err)
+	}
+	assert.Equal(t, 2, created) // Register 与第一次 LookupDict
+}
+
+func TestSampler(t *testing.T) {
+	s := NewSampler(10)
+	s.Add(nil)
+	assert.Equal(t, 0, len(s.Samples()))
+	for i := 0; i < 100; i++ {
+		data := []byte(fmt.Sprintf(`{"id":%d,"method":"ArithService.Add"}`, i))
+		s.Add(data)
+		data[0] = 0 // samples are copied
+	}
+	samples := s.Samples()
+	assert.Equal(t, 10, len(samples))
+	for _, sample := range samples {
+		assert.Equal(t, byte('{'), sample[0])
+	}
+	dict, err := s.Train(256)
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, 0, len(dict))
+}
+
+func BenchmarkCompressor_Zip(b *testing.B) {
+	for _, ct := range compressTypes {
+		for _, p := range payloads[1:] {
+			b.Run(ct.name+"-"+p.name, func(b *testing.B) {
+				c, _ := Lookup(ct.t)
+				zipped, _ := c.Zip(p.data)
+				b.SetBytes(int64(len(p.data)))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					c.Zip(p.data)
+				}
+				b.ReportMetric(float64(len(zipped))/float64(len(p.data)), "ratio")
+			})
+		}
+	}
+}
+
+func BenchmarkCompressor_Unzip(b *testing.B) {
+	for _, ct := range compressTypes {
+		for _, p := range payloads[1:] {
+			b.Run(ct.name+"-"+p.name, func(b *testing.B) {
+				c, _ := Lookup(ct.t)
+				zipped, _ := c.Zip(p.data)
+				b.SetBytes(int64(len(p.data)))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					c.Unzip(zipped)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkCompressor_ZipParallel(b *testing.B) {
+	for _, ct := range compressTypes {
+		b.Run(ct.name, func(b *testing.B) {
+			c, _ := Lookup(ct.t)
+			b.SetBytes(int64(len(largePayload)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					zipped, _ := c.Zip(largePayload)
+					c.Unzip(zipped)
+				}
+			})
+		})
+	}
+}
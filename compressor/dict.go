@@ -0,0 +1,190 @@
+package compressor
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+
+	"github.com/klauspost/compress/dict"
+)
+
+var (
+	ErrDictExists       = errors.New("dictionary id already registered")
+	ErrNotFoundDict     = errors.New("not found dictionary")
+	ErrInvalidDictID    = errors.New("dictionary id must not be 0")
+	ErrEmptyDict        = errors.New("dictionary is empty")
+	ErrDictNotSupported = errors.New("compressor does not support dictionaries")
+)
+
+// DictCompressor is implemented by compressors that support shared dictionaries (zlib
+// and zstd), a Factory that receives Options.Dict returns one with the same DictID
+type DictCompressor interface {
+	Compressor
+	DictID() uint32 // 0 means no dictionary
+}
+
+type dictKey struct {
+	t  CompressType
+	id uint32
+}
+
+// dicts 共享字典及按字典创建的压缩器
+var dicts = struct {
+	sync.RWMutex
+	data        map[uint32][]byte
+	compressors map[dictKey]Compressor
+}{
+	data:        make(map[uint32][]byte),
+	compressors: make(map[dictKey]Compressor),
+}
+
+// RegisterDict registers a shared dictionary under id, the client and the server must
+// register the same dictionary with the same id before it is negotiated on a connection
+func RegisterDict(id uint32, dict []byte) error {
+	if id == 0 {
+		return ErrInvalidDictID
+	}
+	if len(dict) == 0 {
+		return ErrEmptyDict
+	}
+	dicts.Lock()
+	defer dicts.Unlock()
+	if _, ok := dicts.data[id]; ok {
+		return ErrDictExists
+	}
+	dicts.data[id] = dict
+	return nil
+}
+
+// UnregisterDict removes the dictionary id and the compressors created with it,
+// connections that negotiated it keep using their compressors
+func UnregisterDict(id uint32) error {
+	dicts.Lock()
+	defer dicts.Unlock()
+	if _, ok := dicts.data[id]; !ok {
+		return ErrNotFoundDict
+	}
+	delete(dicts.data, id)
+	for key := range dicts.compressors {
+		if key.id == id {
+			delete(dicts.compressors, key)
+		}
+	}
+	return nil
+}
+
+// HasDict reports whether the dictionary id is registered
+func HasDict(id uint32) bool {
+	dicts.RLock()
+	defer dicts.RUnlock()
+	_, ok := dicts.data[id]
+	return ok
+}
+
+// LookupDict returns the compressor of t created with the dictionary id and the
+// default options, it is created once and shared by codecs
+func LookupDict(t CompressType, id uint32) (Compressor, error) {
+	key := dictKey{t, id}
+	dicts.RLock()
+	c, ok := dicts.compressors[key]
+	data, found := dicts.data[id]
+	dicts.RUnlock()
+	if ok {
+		if c == nil {
+			return nil, ErrDictNotSupported
+		}
+		return c, nil
+	}
+	if !found {
+		return nil, ErrNotFoundDict
+	}
+
+	c, err := New(t, WithDict(id, data))
+	if err != nil {
+		return nil, err
+	}
+	if dc, ok := c.(DictCompressor); !ok || dc.DictID() != id {
+		c = nil // 缓存不支持字典的结果，避免每帧重新创建
+	}
+	dicts.Lock()
+	defer dicts.Unlock()
+	if _, ok := dicts.data[id]; !ok { // 创建期间被 UnregisterDict
+		return nil, ErrNotFoundDict
+	}
+	if cached, ok := dicts.compressors[key]; ok {
+		c = cached
+	} else {
+		dicts.compressors[key] = c
+	}
+	if c == nil {
+		return nil, ErrDictNotSupported
+	}
+	return c, nil
+}
+
+// dropDictCompressors removes the compressors of t created with the dictionaries
+func dropDictCompressors(t CompressType) {
+	dicts.Lock()
+	defer dicts.Unlock()
+	for key := range dicts.compressors {
+		if key.t == t {
+			delete(dicts.compressors, key)
+		}
+	}
+}
+
+// TrainDict builds a raw content dictionary of at most size bytes from sample payloads,
+// the result can be registered for both zlib and zstd
+func TrainDict(samples [][]byte, size int) ([]byte, error) {
+	return dict.BuildRawDict(samples, dict.Options{MaxDictSize: size, HashBytes: 6})
+}
+
+// Sampler keeps a uniform random sample of at most max payloads seen by a server,
+// see tinyrpc.WithDictSampler. It is safe for concurrent use.
+type Sampler struct {
+	mu      sync.Mutex
+	max     int
+	seen    int
+	samples [][]byte
+	rand    *rand.Rand
+}
+
+// NewSampler create a sampler that keeps at most max payloads
+func NewSampler(max int) *Sampler {
+	return &Sampler{max: max, rand: rand.New(rand.NewSource(rand.Int63()))}
+}
+
+// Add offers a payload to the sampler, data is copied if it is kept
+func (s *Sampler) Add(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen++
+	// 蓄水池抽样，每个 payload 被保留的概率相同
+	i := len(s.samples)
+	if i >= s.max {
+		if i = s.rand.Intn(s.seen); i >= s.max {
+			return
+		}
+	}
+	sample := append([]byte(nil), data...)
+	if i == len(s.samples) {
+		s.samples = append(s.samples, sample)
+	} else {
+		s.samples[i] = sample
+	}
+}
+
+// Samples returns the payloads kept so far
+func (s *Sampler) Samples() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.samples...)
+}
+
+// Train builds a dictionary of at most size bytes from the payloads kept so far
+func (s *Sampler) Train(size int) ([]byte, error) {
+	return TrainDict(s.Samples(), size)
+}
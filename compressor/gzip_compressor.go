@@ -3,44 +3,77 @@ package compressor
 import (
 	"bytes"
 	"compress/gzip"
-	"io"
-	"io/ioutil"
+	"sync"
 )
 
 func init() {
-	Compressors[Gzip] = NewGzipCompressor()
+	mustRegister(Gzip, "gzip", func(o Options) (Compressor, error) {
+		return NewGzipCompressorLevel(o.Level)
+	})
 }
 
-type GzipCompressor struct{}
+// GzipCompressor reuses gzip writers and readers through sync.Pool,
+// since a gzip.Writer holds about 800KB of state
+type GzipCompressor struct {
+	level   int
+	writers sync.Pool // *gzip.Writer
+	readers sync.Pool // *gzip.Reader
+}
 
+// NewGzipCompressor create a gzip compressor with the default level
 func NewGzipCompressor() Compressor {
-	return &GzipCompressor{}
+	c, _ := NewGzipCompressorLevel(gzip.DefaultCompression)
+	return c
 }
 
-func (*GzipCompressor) Zip(data []byte) ([]byte, error) {
-	buf := bytes.NewBuffer(nil)
-	w := gzip.NewWriter(buf)
-	defer w.Close()
-	_, err := w.Write(data)
-	if err != nil {
+// NewGzipCompressorLevel create a gzip compressor, level ranges from gzip.HuffmanOnly to gzip.BestCompression
+func NewGzipCompressorLevel(level int) (Compressor, error) {
+	if _, err := gzip.NewWriterLevel(nil, level); err != nil {
 		return nil, err
 	}
-	err = w.Flush()
-	if err != nil {
+	c := &GzipCompressor{level: level}
+	c.writers.New = func() any {
+		w, _ := gzip.NewWriterLevel(nil, c.level)
+		return w
+	}
+	return c, nil
+}
+
+func (c *GzipCompressor) Zip(data []byte) ([]byte, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	w := c.writers.Get().(*gzip.Writer)
+	defer c.writers.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), err
+	return copyBytes(buf), nil
 }
 
-func (*GzipCompressor) Unzip(data []byte) ([]byte, error) {
-	r, err := gzip.NewReader(bytes.NewBuffer(data))
+func (c *GzipCompressor) Unzip(data []byte) ([]byte, error) {
+	var (
+		r   *gzip.Reader
+		err error
+	)
+	if pooled := c.readers.Get(); pooled != nil {
+		r = pooled.(*gzip.Reader)
+		err = r.Reset(bytes.NewReader(data))
+	} else {
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer r.Close()
-	data, err = ioutil.ReadAll(r)
-	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+	defer c.readers.Put(r)
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err = readDecoded(buf, r); err != nil {
 		return nil, err
 	}
-	return data, nil
+	return copyBytes(buf), nil
 }
@@ -0,0 +1,100 @@
+package compressor
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+func init() {
+	mustRegister(LZ4, "lz4", func(Options) (Compressor, error) {
+		return NewLZ4Compressor(), nil
+	})
+}
+
+// lz4 块格式不记录原始长度，压缩结果的格式为：
+//
+//	+-------+------------------+----------------------+
+//	|  mode | uncompressed len |        payload       |
+//	+-------+------------------+----------------------+
+//	| uint8 |      uvarint     | lz4 block / raw data |
+//	+-------+------------------+----------------------+
+const (
+	lz4Stored byte = iota // 数据不可压缩，payload 为原始数据
+	lz4Block
+)
+
+// lz4MaxRatio lz4 块格式的最大压缩比，用于校验原始长度，避免恶意数据导致过大的内存分配
+const lz4MaxRatio = 255
+
+var ErrInvalidLZ4Block = errors.New("invalid lz4 block")
+
+// LZ4Compressor lz4 block format compressor, fast enough for latency-sensitive links
+type LZ4Compressor struct {
+	compressors sync.Pool // *lz4.Compressor, its hash table is reused between calls
+}
+
+func NewLZ4Compressor() Compressor {
+	return &LZ4Compressor{
+		compressors: sync.Pool{New: func() any { return &lz4.Compressor{} }},
+	}
+}
+
+// Zip .
+func (c *LZ4Compressor) Zip(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	buf := make([]byte, 1+binary.MaxVarintLen64+lz4.CompressBlockBound(len(data)))
+	idx := 1
+	idx += binary.PutUvarint(buf[idx:], uint64(len(data)))
+
+	compressor := c.compressors.Get().(*lz4.Compressor)
+	n, err := compressor.CompressBlock(data, buf[idx:])
+	c.compressors.Put(compressor)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 || n >= len(data) { // 不可压缩
+		buf[0] = lz4Stored
+		return append(buf[:idx], data...), nil
+	}
+	buf[0] = lz4Block
+	return buf[:idx+n], nil
+}
+
+// Unzip .
+func (c *LZ4Compressor) Unzip(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	length, size := binary.Uvarint(data[1:])
+	if size <= 0 {
+		return nil, ErrInvalidLZ4Block
+	}
+	payload := data[1+size:]
+	switch data[0] {
+	case lz4Stored:
+		if uint64(len(payload)) != length {
+			return nil, ErrInvalidLZ4Block
+		}
+		return payload, nil
+	case lz4Block:
+		if length > uint64(len(payload))*lz4MaxRatio {
+			return nil, ErrInvalidLZ4Block
+		}
+		buf := make([]byte, length)
+		n, err := lz4.UncompressBlock(payload, buf)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(n) != length {
+			return nil, ErrInvalidLZ4Block
+		}
+		return buf, nil
+	default:
+		return nil, ErrInvalidLZ4Block
+	}
+}
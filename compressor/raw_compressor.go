@@ -1,7 +1,9 @@
 package compressor
 
 func init() {
-	Compressors[Raw] = NewRawCompressor()
+	mustRegister(Raw, "raw", func(Options) (Compressor, error) {
+		return NewRawCompressor(), nil
+	})
 }
 
 type RawCompressor struct{}
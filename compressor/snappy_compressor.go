@@ -3,13 +3,14 @@ package compressor
 import (
 	"bytes"
 	"io"
-	"io/ioutil"
 
 	"github.com/golang/snappy"
 )
 
 func init() {
-	Compressors[Snappy] = NewSnappyCompressor()
+	mustRegister(Snappy, "snappy", func(Options) (Compressor, error) {
+		return NewSnappyCompressor(), nil
+	})
 }
 
 type SnappyCompressor struct{}
@@ -39,9 +40,10 @@ func (*SnappyCompressor) Zip(data []byte) ([]byte, error) {
 // Unzip .
 func (*SnappyCompressor) Unzip(data []byte) ([]byte, error) {
 	r := snappy.NewReader(bytes.NewBuffer(data))
-	data, err := ioutil.ReadAll(r)
-	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := readDecoded(buf, r); err != nil && err != io.ErrUnexpectedEOF {
 		return nil, err
 	}
-	return data, nil
+	return copyBytes(buf), nil
 }
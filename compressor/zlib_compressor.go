@@ -4,45 +4,95 @@ import (
 	"bytes"
 	"compress/zlib"
 	"io"
-	"io/ioutil"
+	"sync"
 )
 
 func init() {
-	Compressors[Zlib] = NewZlibCompressor()
+	mustRegister(Zlib, "zlib", func(o Options) (Compressor, error) {
+		return NewZlibDictCompressor(o.Level, o.DictID, o.Dict)
+	})
 }
 
-type ZlibCompressor struct{}
+// ZlibCompressor reuses zlib writers and readers through sync.Pool
+type ZlibCompressor struct {
+	level   int
+	dictID  uint32
+	dict    []byte    // preset dictionary
+	writers sync.Pool // *zlib.Writer
+	readers sync.Pool // io.ReadCloser implementing zlib.Resetter
+}
 
+// NewZlibCompressor create a zlib compressor with the default level
 func NewZlibCompressor() Compressor {
-	return &ZlibCompressor{}
+	c, _ := NewZlibCompressorLevel(zlib.DefaultCompression)
+	return c
+}
+
+// NewZlibCompressorLevel create a zlib compressor, level ranges from zlib.HuffmanOnly to zlib.BestCompression
+func NewZlibCompressorLevel(level int) (Compressor, error) {
+	return NewZlibDictCompressor(level, 0, nil)
+}
+
+// NewZlibDictCompressor create a zlib compressor with the preset dictionary dict. With a
+// dictionary the default level is zlib.BestCompression, since the lower levels of
+// compress/flate store short inputs as is and never refer to the dictionary.
+func NewZlibDictCompressor(level int, id uint32, dict []byte) (Compressor, error) {
+	if len(dict) != 0 && level == zlib.DefaultCompression {
+		level = zlib.BestCompression
+	}
+	if _, err := zlib.NewWriterLevelDict(nil, level, dict); err != nil {
+		return nil, err
+	}
+	c := &ZlibCompressor{level: level, dictID: id, dict: dict}
+	c.writers.New = func() any {
+		w, _ := zlib.NewWriterLevelDict(nil, c.level, c.dict)
+		return w
+	}
+	return c, nil
+}
+
+// DictID returns the id of the preset dictionary
+func (c *ZlibCompressor) DictID() uint32 {
+	return c.dictID
 }
 
 // Zip .
-func (*ZlibCompressor) Zip(data []byte) ([]byte, error) {
-	buf := bytes.NewBuffer(nil)
-	w := zlib.NewWriter(buf)
-	defer w.Close()
-	_, err := w.Write(data)
-	if err != nil {
+func (c *ZlibCompressor) Zip(data []byte) ([]byte, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	w := c.writers.Get().(*zlib.Writer)
+	defer c.writers.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(data); err != nil {
 		return nil, err
 	}
-	err = w.Flush()
-	if err != nil {
+	if err := w.Close(); err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), err
+	return copyBytes(buf), nil
 }
 
 // Unzip .
-func (*ZlibCompressor) Unzip(data []byte) ([]byte, error) {
-	r, err := zlib.NewReader(bytes.NewBuffer(data))
+func (c *ZlibCompressor) Unzip(data []byte) ([]byte, error) {
+	var (
+		r   io.ReadCloser
+		err error
+	)
+	if pooled := c.readers.Get(); pooled != nil {
+		r = pooled.(io.ReadCloser)
+		err = r.(zlib.Resetter).Reset(bytes.NewReader(data), c.dict)
+	} else {
+		r, err = zlib.NewReaderDict(bytes.NewReader(data), c.dict)
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer r.Close()
-	data, err = ioutil.ReadAll(r)
-	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+	defer c.readers.Put(r)
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err = readDecoded(buf, r); err != nil {
 		return nil, err
 	}
-	return data, nil
+	return copyBytes(buf), nil
 }
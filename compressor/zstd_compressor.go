@@ -0,0 +1,77 @@
+package compressor
+
+import (
+	"github.com/klauspost/compress/zstd"
+)
+
+// ZstdDefaultLevel 对应 zstd 命令行的默认压缩级别 3
+const ZstdDefaultLevel = 3
+
+const (
+	// ZstdMaxDecodedSize 解压后数据的最大长度，与其他压缩器相同
+	ZstdMaxDecodedSize = MaxDecodedSize
+	// ZstdMaxWindowSize 解压时允许的最大窗口，编码器的窗口不超过 8MB
+	ZstdMaxWindowSize = 8 << 20
+)
+
+func init() {
+	mustRegister(Zstd, "zstd", func(o Options) (Compressor, error) {
+		if o.Level == DefaultLevel {
+			o.Level = ZstdDefaultLevel
+		}
+		return NewZstdDictCompressor(o.Level, o.DictID, o.Dict), nil
+	})
+}
+
+// ZstdCompressor pure Go zstd compressor, the encoder and decoder are created
+// once and shared by all calls since EncodeAll and DecodeAll are safe for
+// concurrent use
+type ZstdCompressor struct {
+	dictID  uint32
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// NewZstdCompressor create a zstd compressor, level follows the zstd levels (1~22)
+// and is mapped to the closest level supported by the encoder
+func NewZstdCompressor(level int) Compressor {
+	return NewZstdDictCompressor(level, 0, nil)
+}
+
+// NewZstdDictCompressor create a zstd compressor with the raw content dictionary dict,
+// id is written to the frames and must not be 0 if dict is set
+func NewZstdDictCompressor(level int, id uint32, dict []byte) Compressor {
+	eopts := []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level))}
+	dopts := []zstd.DOption{
+		zstd.WithDecoderMaxMemory(ZstdMaxDecodedSize),
+		zstd.WithDecoderMaxWindow(ZstdMaxWindowSize),
+	}
+	if len(dict) != 0 {
+		eopts = append(eopts, zstd.WithEncoderDictRaw(id, dict))
+		dopts = append(dopts, zstd.WithDecoderDictRaw(id, dict))
+	}
+	encoder, err := zstd.NewWriter(nil, eopts...)
+	if err != nil {
+		panic(err) // only returned for invalid options
+	}
+	decoder, err := zstd.NewReader(nil, dopts...)
+	if err != nil {
+		panic(err)
+	}
+	return &ZstdCompressor{dictID: id, encoder: encoder, decoder: decoder}
+}
+
+// DictID returns the id of the dictionary
+func (c *ZstdCompressor) DictID() uint32 {
+	return c.dictID
+}
+
+// Zip .
+func (c *ZstdCompressor) Zip(data []byte) ([]byte, error) {
+	return c.encoder.EncodeAll(data, make([]byte, 0, len(data))), nil
+}
+
+// Unzip .
+func (c *ZstdCompressor) Unzip(data []byte) ([]byte, error) {
+	return c.decoder.DecodeAll(data, nil)
+}
@@ -0,0 +1,85 @@
+package tinyrpc
+
+import (
+	"sync"
+	"time"
+	"tinyrpc/codec"
+)
+
+// tokenRefreshMargin 令牌在过期前提前刷新的时间
+const tokenRefreshMargin = 10 * time.Second
+
+// Credentials provides the metadata attached to each call of the client
+type Credentials interface {
+	Metadata(serviceMethod string) (map[string]string, error)
+}
+
+// WithCredentials attach the metadata of creds to every call of the client,
+// an error of creds fails the call
+func WithCredentials(creds Credentials) Option {
+	return func(o *options) {
+		o.credentials = creds
+	}
+}
+
+func credentialsMetadata(creds Credentials) codec.MetadataFunc {
+	if creds == nil {
+		return nil
+	}
+	return creds.Metadata
+}
+
+// staticToken sends the same bearer token with every call
+type staticToken map[string]string
+
+// StaticToken returns the credentials of a bearer token that never changes
+func StaticToken(token string) Credentials {
+	return staticToken{AuthorizationKey: "Bearer " + token}
+}
+
+// Metadata .
+func (t staticToken) Metadata(string) (map[string]string, error) {
+	return t, nil
+}
+
+// TokenSource returns a new token and the time it expires, a zero expiry never expires
+type TokenSource func() (token string, expiry time.Time, err error)
+
+type tokenCredentials struct {
+	mu       sync.Mutex
+	source   TokenSource
+	metadata map[string]string
+	expiry   time.Time
+}
+
+// NewTokenCredentials returns the credentials of the bearer tokens of source, the token
+// is cached and refreshed shortly before it expires
+func NewTokenCredentials(source TokenSource) Credentials {
+	return &tokenCredentials{source: source}
+}
+
+// Metadata .
+func (c *tokenCredentials) Metadata(string) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.metadata != nil && (c.expiry.IsZero() || time.Now().Add(tokenRefreshMargin).Before(c.expiry)) {
+		return c.metadata, nil
+	}
+	token, expiry, err := c.source()
+	if err != nil {
+		return nil, err
+	}
+	c.metadata = map[string]string{AuthorizationKey: "Bearer " + token}
+	c.expiry = expiry
+	return c.metadata, nil
+}
+
+// HMACCredentials returns the credentials of the tokens created by NewHMACToken,
+// a new token is created before the previous one expires
+func HMACCredentials(key []byte, subject string, ttl time.Duration) Credentials {
+	return NewTokenCredentials(func() (string, time.Time, error) {
+		expiry := time.Now().Add(ttl)
+		token, err := NewHMACToken(key, subject, ttl)
+		return token, expiry, err
+	})
+}
@@ -1,15 +1,22 @@
 module tinyrpc
 
-go 1.18
+go 1.21
 
 require (
+	github.com/cespare/xxhash/v2 v2.2.0
+	github.com/fxamacker/cbor/v2 v2.7.0
 	github.com/golang/snappy v0.0.4
+	github.com/klauspost/compress v1.17.11
+	github.com/pierrec/lz4/v4 v4.1.21
 	github.com/stretchr/testify v1.8.1
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 	google.golang.org/protobuf v1.28.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
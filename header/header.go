@@ -3,41 +3,73 @@ package header
 import (
 	"encoding/binary"
 	"errors"
+	"sort"
 	"sync"
+	"tinyrpc/checksum"
 	"tinyrpc/compressor"
 )
 
 const (
-	// MaxHeaderSize = 2 + 10 + 10 + 10 + 4 (10 refer to binary.MaxVarintLen64)
-	MaxHeaderSize = 36
+	// MaxHeaderSize = 2 + 10 + 10 + 10 + 1 + 10 + 1 + 10 + 10 (10 refer to binary.MaxVarintLen64)
+	MaxHeaderSize = 64
 	Uint32Size    = 4 // byte
 	Uint16Size    = 2
+	Uint8Size     = 1
 )
 
 var ErrUnmarshal = errors.New("unmarshal error")
 
+// Flag request flags
+type Flag uint8
+
+const (
+	// FlagOneWay the server executes the request but never responds to it
+	FlagOneWay Flag = 1 << iota
+	// FlagDict the body is compressed with the shared dictionary DictID
+	FlagDict
+)
+
 // RequestHeader request header structure looks like:
-// 	+--------------+----------------+----------+------------+----------+
-// 	| CompressType |      Method    |    ID    | RequestLen | Checksum |
-// 	+--------------+----------------+----------+------------+----------+
-// 	|    uint16    | uvarint+string |  uvarint |   uvarint  |  uint32  |
-// 	+--------------+----------------+----------+------------+----------+
+// 	+--------------+----------------+----------+------------+--------------+---------------+-------+-----------------+---------+-----------------+
+// 	| CompressType |      Method    |    ID    | RequestLen | ChecksumType |    Checksum   | Flags |      Accept     |  DictID |     Metadata    |
+// 	+--------------+----------------+----------+------------+--------------+---------------+-------+-----------------+---------+-----------------+
+// 	|    uint16    | uvarint+string |  uvarint |   uvarint  |     uint8    | uvarint+bytes | uint8 | uvarint+uint16s | uvarint | uvarint+strings |
+// 	+--------------+----------------+----------+------------+--------------+---------------+-------+-----------------+---------+-----------------+
+// Metadata is omitted if it is empty.
 type RequestHeader struct {
 	sync.RWMutex
-	CompressType compressor.CompressType // 表示RPC的协议内容的压缩类型，TinyRPC支持四种压缩类型，Raw、Gzip、Snappy、Zlib
-	Method       string                  // 方法名
-	ID           uint64                  // 请求ID
-	RequestLen   uint32                  // 请求体长度
-	Checksum     uint32                  // 请求体校验 使用CRC32摘要算法
+	CompressType compressor.CompressType   // 表示RPC的协议内容的压缩类型，TinyRPC支持四种压缩类型，Raw、Gzip、Snappy、Zlib
+	Method       string                    // 方法名
+	ID           uint64                    // 请求ID
+	RequestLen   uint32                    // 请求体长度
+	ChecksumType checksum.Type             // 请求体校验算法
+	Checksum     []byte                    // 请求体校验值
+	Flags        Flag                      // 请求标志位
+	Accept       []compressor.CompressType // 客户端可解压的响应压缩类型，按优先级排列
+	DictID       uint32                    // 客户端提议的共享字典，设置 FlagDict 时请求体使用该字典压缩
+	Metadata     map[string]string         // 请求元数据，如认证信息
 }
 
 // Marshal will encode request header into a byte slice
 func (r *RequestHeader) Marshal() []byte {
 	r.RLock()
 	defer r.RUnlock()
+	return r.marshal(r.Checksum)
+}
+
+// Unsigned encodes the header without the checksum, keyed checksums sign it with the body
+func (r *RequestHeader) Unsigned() []byte {
+	r.RLock()
+	defer r.RUnlock()
+	return r.marshal(nil)
+}
+
+func (r *RequestHeader) marshal(sum []byte) []byte {
 	idx := 0
-	// MaxHeaderSize = 2 + 10 + len(string) + 10 + 10 + 4
-	header := make([]byte, MaxHeaderSize+len(r.Method))
+	// MaxHeaderSize = 2 + 10 + len(string) + 10 + 10 + 1 + 10 + len(checksum) + 1 + 10 + 2*len(accept)
+	// + 10 + (10 + len(key) + 10 + len(value))*len(metadata)
+	size := MaxHeaderSize + len(r.Method) + len(sum) + Uint16Size*len(r.Accept) + metadataSize(r.Metadata)
+	header := make([]byte, size)
 
 	// 将 uint16 数字编码写入 header
 	binary.LittleEndian.PutUint16(header[idx:], uint16(r.CompressType))
@@ -47,8 +79,20 @@ func (r *RequestHeader) Marshal() []byte {
 	idx += binary.PutUvarint(header[idx:], r.ID)
 	idx += binary.PutUvarint(header[idx:], uint64(r.RequestLen))
 
-	binary.LittleEndian.PutUint32(header[idx:], r.Checksum)
-	idx += Uint32Size
+	header[idx] = byte(r.ChecksumType)
+	idx += Uint8Size
+	idx += writeBytes(header[idx:], sum)
+
+	header[idx] = byte(r.Flags)
+	idx += Uint8Size
+
+	idx += binary.PutUvarint(header[idx:], uint64(len(r.Accept)))
+	for _, t := range r.Accept {
+		binary.LittleEndian.PutUint16(header[idx:], uint16(t))
+		idx += Uint16Size
+	}
+	idx += binary.PutUvarint(header[idx:], uint64(r.DictID))
+	idx += writeMetadata(header[idx:], r.Metadata)
 	return header[:idx]
 }
 
@@ -79,7 +123,30 @@ func (r *RequestHeader) Unmarshal(data []byte) (err error) {
 	r.RequestLen = uint32(length)
 	idx += size
 
-	r.Checksum = binary.LittleEndian.Uint32(data[idx:])
+	r.ChecksumType = checksum.Type(data[idx])
+	idx += Uint8Size
+
+	r.Checksum, size = readBytes(data[idx:])
+	idx += size
+
+	r.Flags = Flag(data[idx])
+	idx += Uint8Size
+
+	n, size := binary.Uvarint(data[idx:])
+	idx += size
+	if n > uint64(len(data)-idx)/Uint16Size {
+		return ErrUnmarshal
+	}
+	for i := uint64(0); i < n; i++ {
+		r.Accept = append(r.Accept, compressor.CompressType(binary.LittleEndian.Uint16(data[idx:])))
+		idx += Uint16Size
+	}
+
+	dictID, size := binary.Uvarint(data[idx:])
+	r.DictID = uint32(dictID)
+	idx += size
+
+	r.Metadata, err = readMetadata(data[idx:])
 	return
 }
 
@@ -90,6 +157,48 @@ func (r *RequestHeader) GetCompressType() compressor.CompressType {
 	return r.CompressType
 }
 
+// GetChecksumType get checksum type
+func (r *RequestHeader) GetChecksumType() checksum.Type {
+	r.RLock()
+	defer r.RUnlock()
+	return r.ChecksumType
+}
+
+// GetAccept get the compress types accepted for the response
+func (r *RequestHeader) GetAccept() []compressor.CompressType {
+	r.RLock()
+	defer r.RUnlock()
+	return r.Accept
+}
+
+// GetDictID get the shared dictionary proposed by the client
+func (r *RequestHeader) GetDictID() uint32 {
+	r.RLock()
+	defer r.RUnlock()
+	return r.DictID
+}
+
+// GetMetadata get the request metadata
+func (r *RequestHeader) GetMetadata() map[string]string {
+	r.RLock()
+	defer r.RUnlock()
+	return r.Metadata
+}
+
+// IsOneWay reports whether the request expects no response
+func (r *RequestHeader) IsOneWay() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.Flags&FlagOneWay != 0
+}
+
+// UsesDict reports whether the body is compressed with the dictionary DictID
+func (r *RequestHeader) UsesDict() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.Flags&FlagDict != 0
+}
+
 // GetMethod get method
 func (r *RequestHeader) GetMethod() string {
 	r.RLock()
@@ -103,33 +212,54 @@ func (r *RequestHeader) ResetHeader() {
 	defer r.Unlock()
 	r.ID = 0
 	r.Method = ""
-	r.Checksum = 0
+	r.ChecksumType = 0
+	r.Checksum = nil
+	r.Flags = 0
+	r.Accept = nil
+	r.DictID = 0
+	r.Metadata = nil
 	r.CompressType = 0
 	r.RequestLen = 0
 }
 
 // ResponseHeader request header structure looks like:
-// 	+--------------+---------+----------------+-------------+----------+
-// 	| CompressType |    ID   |      Error     | ResponseLen | Checksum |
-// 	+--------------+---------+----------------+-------------+----------+
-// 	|    uint16    | uvarint | uvarint+string |    uvarint  |  uint32  |
-// 	+--------------+---------+----------------+-------------+----------+
+// 	+--------------+---------+----------------+-------------+--------------+---------------+-------+---------+-----------------+
+// 	| CompressType |    ID   |      Error     | ResponseLen | ChecksumType |    Checksum   | Flags |  DictID |     Metadata    |
+// 	+--------------+---------+----------------+-------------+--------------+---------------+-------+---------+-----------------+
+// 	|    uint16    | uvarint | uvarint+string |    uvarint  |     uint8    | uvarint+bytes | uint8 | uvarint | uvarint+strings |
+// 	+--------------+---------+----------------+-------------+--------------+---------------+-------+---------+-----------------+
+// Metadata is omitted if it is empty.
 type ResponseHeader struct {
 	sync.RWMutex
 	CompressType compressor.CompressType // 压缩类型
 	ID           uint64                  // 响应ID号
 	Error        string                  // 错误信息
 	ResponseLen  uint32                  // 响应体长度
-	Checksum     uint32                  // 响应体校验码
+	ChecksumType checksum.Type           // 响应体校验算法
+	Checksum     []byte                  // 响应体校验码
+	Flags        Flag                    // 响应标志位
+	DictID       uint32                  // 服务端接受的共享字典，0 表示不使用字典
+	Metadata     map[string]string       // 响应元数据，如限流后的重试间隔
 }
 
 // Marshal will encode request header into a byte slice
 func (r *ResponseHeader) Marshal() []byte {
 	r.RLock()
 	defer r.RUnlock()
+	return r.marshal(r.Checksum)
+}
+
+// Unsigned encodes the header without the checksum, keyed checksums sign it with the body
+func (r *ResponseHeader) Unsigned() []byte {
+	r.RLock()
+	defer r.RUnlock()
+	return r.marshal(nil)
+}
+
+func (r *ResponseHeader) marshal(sum []byte) []byte {
 	idx := 0
-	// MaxHeaderSize = 2 + 10 + len(string) + 10 + 10 + 4
-	header := make([]byte, MaxHeaderSize+len(r.Error))
+	// MaxHeaderSize = 2 + 10 + len(string) + 10 + 10 + 1 + 10 + len(checksum) + 1 + 10
+	header := make([]byte, MaxHeaderSize+len(r.Error)+len(sum)+metadataSize(r.Metadata))
 
 	// 将 uint16 数字编码写入 header
 	binary.LittleEndian.PutUint16(header[idx:], uint16(r.CompressType))
@@ -139,8 +269,14 @@ func (r *ResponseHeader) Marshal() []byte {
 	idx += writeString(header[idx:], r.Error)
 	idx += binary.PutUvarint(header[idx:], uint64(r.ResponseLen))
 
-	binary.LittleEndian.PutUint32(header[idx:], r.Checksum)
-	idx += Uint32Size
+	header[idx] = byte(r.ChecksumType)
+	idx += Uint8Size
+	idx += writeBytes(header[idx:], sum)
+
+	header[idx] = byte(r.Flags)
+	idx += Uint8Size
+	idx += binary.PutUvarint(header[idx:], uint64(r.DictID))
+	idx += writeMetadata(header[idx:], r.Metadata)
 	return header[:idx]
 }
 
@@ -171,7 +307,20 @@ func (r *ResponseHeader) Unmarshal(data []byte) (err error) {
 	r.ResponseLen = uint32(length)
 	idx += size
 
-	r.Checksum = binary.LittleEndian.Uint32(data[idx:])
+	r.ChecksumType = checksum.Type(data[idx])
+	idx += Uint8Size
+
+	r.Checksum, size = readBytes(data[idx:])
+	idx += size
+
+	r.Flags = Flag(data[idx])
+	idx += Uint8Size
+
+	dictID, size := binary.Uvarint(data[idx:])
+	r.DictID = uint32(dictID)
+	idx += size
+
+	r.Metadata, err = readMetadata(data[idx:])
 	return
 }
 
@@ -182,13 +331,45 @@ func (r *ResponseHeader) GetCompressType() compressor.CompressType {
 	return r.CompressType
 }
 
+// GetChecksumType get checksum type
+func (r *ResponseHeader) GetChecksumType() checksum.Type {
+	r.RLock()
+	defer r.RUnlock()
+	return r.ChecksumType
+}
+
+// GetDictID get the shared dictionary accepted by the server
+func (r *ResponseHeader) GetDictID() uint32 {
+	r.RLock()
+	defer r.RUnlock()
+	return r.DictID
+}
+
+// GetMetadata get the response metadata
+func (r *ResponseHeader) GetMetadata() map[string]string {
+	r.RLock()
+	defer r.RUnlock()
+	return r.Metadata
+}
+
+// UsesDict reports whether the body is compressed with the dictionary DictID
+func (r *ResponseHeader) UsesDict() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.Flags&FlagDict != 0
+}
+
 // ResetHeader reset request header
 func (r *ResponseHeader) ResetHeader() {
 	r.Lock()
 	defer r.Unlock()
 	r.ID = 0
 	r.Error = ""
-	r.Checksum = 0
+	r.ChecksumType = 0
+	r.Checksum = nil
+	r.Flags = 0
+	r.DictID = 0
+	r.Metadata = nil
 	r.CompressType = 0
 	r.ResponseLen = 0
 }
@@ -209,3 +390,73 @@ func writeString(data []byte, str string) int {
 	idx += len(str)
 	return idx
 }
+
+func readBytes(data []byte) ([]byte, int) {
+	idx := 0
+	bytesLen, size := binary.Uvarint(data)
+	idx += size
+	if bytesLen == 0 {
+		return nil, idx
+	}
+	b := make([]byte, bytesLen)
+	idx += copy(b, data[idx:idx+int(bytesLen)])
+	return b, idx
+}
+
+func writeBytes(data []byte, b []byte) int {
+	idx := 0
+	idx += binary.PutUvarint(data, uint64(len(b)))
+	copy(data[idx:], b)
+	idx += len(b)
+	return idx
+}
+
+// metadataSize the max size of the encoded metadata
+func metadataSize(md map[string]string) int {
+	if len(md) == 0 {
+		return 0
+	}
+	size := binary.MaxVarintLen64
+	for k, v := range md {
+		size += 2*binary.MaxVarintLen64 + len(k) + len(v)
+	}
+	return size
+}
+
+// writeMetadata writes the count and the sorted key value pairs, empty metadata is omitted
+func writeMetadata(data []byte, md map[string]string) int {
+	if len(md) == 0 {
+		return 0
+	}
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys) // 相同的元数据编码结果相同
+	idx := binary.PutUvarint(data, uint64(len(keys)))
+	for _, k := range keys {
+		idx += writeString(data[idx:], k)
+		idx += writeString(data[idx:], md[k])
+	}
+	return idx
+}
+
+// readMetadata reads the metadata at the end of the header, nil if it is omitted
+func readMetadata(data []byte) (map[string]string, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	n, idx := binary.Uvarint(data)
+	if n > uint64(len(data)-idx)/2 { // 每个键值对至少 2 字节
+		return nil, ErrUnmarshal
+	}
+	md := make(map[string]string, n)
+	for i := uint64(0); i < n; i++ {
+		k, size := readString(data[idx:])
+		idx += size
+		v, size := readString(data[idx:])
+		idx += size
+		md[k] = v
+	}
+	return md, nil
+}
@@ -3,6 +3,7 @@ package header
 import (
 	"reflect"
 	"testing"
+	"tinyrpc/checksum"
 	"tinyrpc/compressor"
 
 	"github.com/stretchr/testify/assert"
@@ -24,11 +25,51 @@ func TestRequestHeader_Marshal(t *testing.T) {
 				Method:       "Add",
 				ID:           12455,
 				RequestLen:   266,
-				Checksum:     3845236589,
+				ChecksumType: checksum.CRC32,
+				Checksum:     []byte{0xe5, 0x31, 0xa7, 0x6d},
 			},
 			expect{
 				[]byte{0x0, 0x0, 0x3, 0x41, 0x64, 0x64,
-					0xa7, 0x61, 0x8a, 0x2, 0x6d, 0xa7, 0x31, 0xe5},
+					0xa7, 0x61, 0x8a, 0x2, 0x1, 0x4, 0xe5, 0x31, 0xa7, 0x6d, 0x0, 0x0, 0x0},
+			},
+		},
+		{
+			"test2",
+			&RequestHeader{
+				CompressType: 0,
+				Method:       "Add",
+				ID:           0,
+				RequestLen:   0,
+				ChecksumType: checksum.None,
+				Flags:        FlagOneWay,
+			},
+			expect{
+				[]byte{0x0, 0x0, 0x3, 0x41, 0x64, 0x64,
+					0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0},
+			},
+		},
+		{
+			"test3",
+			&RequestHeader{
+				CompressType: compressor.Raw,
+				Method:       "Add",
+				ChecksumType: checksum.None,
+				Accept:       []compressor.CompressType{compressor.Zstd, compressor.Gzip},
+			},
+			expect{
+				[]byte{0x0, 0x0, 0x3, 0x41, 0x64, 0x64,
+					0x0, 0x0, 0x0, 0x0, 0x0, 0x2, 0x4, 0x0, 0x1, 0x0, 0x0},
+			},
+		},
+		{
+			"test-metadata",
+			&RequestHeader{
+				Method:   "Add",
+				Metadata: map[string]string{"b": "", "a": "xy"},
+			},
+			expect{
+				[]byte{0x0, 0x0, 0x3, 0x41, 0x64, 0x64,
+					0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x2, 0x1, 0x61, 0x2, 0x78, 0x79, 0x1, 0x62, 0x0},
 			},
 		},
 	}
@@ -55,15 +96,60 @@ func TestRequestHeader_Unmarshal(t *testing.T) {
 		{
 			"test-1",
 			[]byte{0x0, 0x0, 0x3, 0x41, 0x64, 0x64,
-				0xa7, 0x61, 0x8a, 0x2, 0x6d, 0xa7, 0x31, 0xe5},
+				0xa7, 0x61, 0x8a, 0x2, 0x1, 0x4, 0xe5, 0x31, 0xa7, 0x6d, 0x0, 0x0, 0x0},
 			expect{&RequestHeader{
 				CompressType: 0,
 				Method:       "Add",
 				ID:           12455,
 				RequestLen:   266,
-				Checksum:     3845236589,
+				ChecksumType: checksum.CRC32,
+				Checksum:     []byte{0xe5, 0x31, 0xa7, 0x6d},
 			}, nil},
 		},
+		{
+			"test-accept",
+			[]byte{0x0, 0x0, 0x3, 0x41, 0x64, 0x64,
+				0x0, 0x0, 0x0, 0x0, 0x0, 0x2, 0x4, 0x0, 0x1, 0x0, 0x0},
+			expect{&RequestHeader{
+				Method: "Add",
+				Accept: []compressor.CompressType{compressor.Zstd, compressor.Gzip},
+			}, nil},
+		},
+		{
+			"test-dict",
+			[]byte{0x0, 0x0, 0x3, 0x41, 0x64, 0x64,
+				0x0, 0x0, 0x0, 0x0, 0x2, 0x0, 0xe7, 0x7},
+			expect{&RequestHeader{
+				Method: "Add",
+				Flags:  FlagDict,
+				DictID: 999,
+			}, nil},
+		},
+		{
+			"test-metadata",
+			[]byte{0x0, 0x0, 0x3, 0x41, 0x64, 0x64,
+				0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x2, 0x1, 0x61, 0x2, 0x78, 0x79, 0x1, 0x62, 0x0},
+			expect{&RequestHeader{
+				Method:   "Add",
+				Metadata: map[string]string{"a": "xy", "b": ""},
+			}, nil},
+		},
+		{
+			"test-metadata-truncated",
+			[]byte{0x0, 0x0, 0x3, 0x41, 0x64, 0x64,
+				0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x2, 0x1, 0x61, 0x2, 0x78, 0x79, 0x1},
+			expect{&RequestHeader{
+				Method: "Add",
+			}, ErrUnmarshal},
+		},
+		{
+			"test-accept-truncated",
+			[]byte{0x0, 0x0, 0x3, 0x41, 0x64, 0x64,
+				0x0, 0x0, 0x0, 0x0, 0x0, 0x2, 0x4, 0x0},
+			expect{&RequestHeader{
+				Method: "Add",
+			}, ErrUnmarshal},
+		},
 		{
 			"test-2",
 			nil,
@@ -94,11 +180,17 @@ func TestResponseHeader_Marshal(t *testing.T) {
 		Error:        "error",
 		ID:           12455,
 		ResponseLen:  266,
-		Checksum:     3845236589,
+		ChecksumType: checksum.CRC32,
+		Checksum:     []byte{0xe5, 0x31, 0xa7, 0x6d},
 	}
 
 	assert.Equal(t, []byte{0x0, 0x0, 0xa7, 0x61, 0x5, 0x65, 0x72,
-		0x72, 0x6f, 0x72, 0x8a, 0x2, 0x6d, 0xa7, 0x31, 0xe5}, header.Marshal())
+		0x72, 0x6f, 0x72, 0x8a, 0x2, 0x1, 0x4, 0xe5, 0x31, 0xa7, 0x6d, 0x0, 0x0}, header.Marshal())
+
+	header.Metadata = map[string]string{"b": "", "a": "xy"}
+	assert.Equal(t, []byte{0x0, 0x0, 0xa7, 0x61, 0x5, 0x65, 0x72,
+		0x72, 0x6f, 0x72, 0x8a, 0x2, 0x1, 0x4, 0xe5, 0x31, 0xa7, 0x6d, 0x0, 0x0,
+		0x2, 0x1, 0x61, 0x2, 0x78, 0x79, 0x1, 0x62, 0x0}, header.Marshal())
 }
 
 // TestResponseHeader_Unmarshal .
@@ -115,15 +207,33 @@ func TestResponseHeader_Unmarshal(t *testing.T) {
 		{
 			"test-1",
 			[]byte{0x0, 0x0, 0xa7, 0x61, 0x5, 0x65, 0x72,
-				0x72, 0x6f, 0x72, 0x8a, 0x2, 0x6d, 0xa7, 0x31, 0xe5},
+				0x72, 0x6f, 0x72, 0x8a, 0x2, 0x1, 0x4, 0xe5, 0x31, 0xa7, 0x6d, 0x0, 0x0},
 			expect{&ResponseHeader{
 				CompressType: 0,
 				Error:        "error",
 				ID:           12455,
 				ResponseLen:  266,
-				Checksum:     3845236589,
+				ChecksumType: checksum.CRC32,
+				Checksum:     []byte{0xe5, 0x31, 0xa7, 0x6d},
+			}, nil},
+		},
+		{
+			"test-metadata",
+			[]byte{0x0, 0x0, 0xa7, 0x61, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+				0x1, 0xe, 0x72, 0x65, 0x74, 0x72, 0x79, 0x2d, 0x61, 0x66, 0x74, 0x65, 0x72, 0x2d, 0x6d, 0x73,
+				0x3, 0x32, 0x35, 0x30},
+			expect{&ResponseHeader{
+				ID:       12455,
+				Metadata: map[string]string{"retry-after-ms": "250"},
 			}, nil},
 		},
+		{
+			"test-metadata-truncated",
+			[]byte{0x0, 0x0, 0xa7, 0x61, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x2, 0x1, 0x61, 0x0},
+			expect{&ResponseHeader{
+				ID: 12455,
+			}, ErrUnmarshal},
+		},
 		{
 			"test-2",
 			nil,
@@ -154,7 +264,8 @@ func TestResponseHeader_ResetHeader(t *testing.T) {
 		Error:        "error",
 		ID:           12455,
 		ResponseLen:  266,
-		Checksum:     3845236589,
+		ChecksumType: checksum.CRC32,
+		Checksum:     []byte{0xe5, 0x31, 0xa7, 0x6d},
 	}
 	header.ResetHeader()
 	assert.Equal(t, true, reflect.DeepEqual(header, &ResponseHeader{}))
@@ -167,7 +278,8 @@ func TestResponseHeader_GetCompressType(t *testing.T) {
 		Error:        "error",
 		ID:           12455,
 		ResponseLen:  266,
-		Checksum:     3845236589,
+		ChecksumType: checksum.CRC32,
+		Checksum:     []byte{0xe5, 0x31, 0xa7, 0x6d},
 	}
 
 	assert.Equal(t, true, reflect.DeepEqual(compressor.CompressType(0), header.GetCompressType()))
@@ -180,8 +292,31 @@ func TestRequestHeader_GetCompressType(t *testing.T) {
 		Method:       "Add",
 		ID:           12455,
 		RequestLen:   266,
-		Checksum:     3845236589,
+		ChecksumType: checksum.CRC32,
+		Checksum:     []byte{0xe5, 0x31, 0xa7, 0x6d},
 	}
 
 	assert.Equal(t, true, reflect.DeepEqual(compressor.CompressType(0), header.GetCompressType()))
 }
+
+// TestRequestHeader_IsOneWay .
+func TestRequestHeader_IsOneWay(t *testing.T) {
+	header := &RequestHeader{Method: "Add"}
+	assert.Equal(t, false, header.IsOneWay())
+	header.Flags |= FlagOneWay
+	assert.Equal(t, true, header.IsOneWay())
+	header.ResetHeader()
+	assert.Equal(t, false, header.IsOneWay())
+}
+
+// TestResponseHeader_Dict .
+func TestResponseHeader_Dict(t *testing.T) {
+	header := &ResponseHeader{ID: 1, Flags: FlagDict, DictID: 999}
+	h := &ResponseHeader{}
+	assert.Equal(t, nil, h.Unmarshal(header.Marshal()))
+	assert.Equal(t, true, h.UsesDict())
+	assert.Equal(t, uint32(999), h.GetDictID())
+	h.ResetHeader()
+	assert.Equal(t, false, h.UsesDict())
+	assert.Equal(t, uint32(0), h.GetDictID())
+}
@@ -0,0 +1,51 @@
+package tinyrpc
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// CallInfo describes a call received by the server
+type CallInfo struct {
+	ServiceMethod string
+	Args          interface{} // decoded args, a pointer unless the handler takes args by value
+	Reply         interface{} // reply filled by the handler
+
+	svc      *service
+	mtype    *methodType
+	argv     reflect.Value
+	replyv   reflect.Value
+	received time.Time // when the request header was read
+}
+
+// Handler handles a call, it is the next interceptor or the validation and the handler
+// of the service at the end of the chain
+type Handler func(ctx context.Context, info *CallInfo) error
+
+// Interceptor intercepts the calls of the server, it calls next to continue the call
+// or returns an error to reject it. The context carries the Peer and the Identity of
+// the connection.
+type Interceptor func(ctx context.Context, info *CallInfo, next Handler) error
+
+// WithInterceptor appends server interceptors, they are called in the order they
+// are added
+func WithInterceptor(interceptors ...Interceptor) Option {
+	return func(o *options) {
+		o.interceptors = append(o.interceptors, interceptors...)
+	}
+}
+
+// admission checks a call before its body is read, it returns the context of the call
+type admission func(ctx context.Context, serviceMethod string) (context.Context, error)
+
+// chain returns the handler that calls the interceptors before h
+func chain(interceptors []Interceptor, h Handler) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], h
+		h = func(ctx context.Context, info *CallInfo) error {
+			return interceptor(ctx, info, next)
+		}
+	}
+	return h
+}
@@ -0,0 +1,213 @@
+package tinyrpc
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // 注册 JWT 使用的哈希算法
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnsupportedAlg = errors.New("unsupported JWT alg")
+	ErrUnknownKey     = errors.New("unknown JWT key")
+)
+
+// jwtAlgs the supported JWS algorithms, "none" is never accepted
+var jwtAlgs = map[string]crypto.Hash{
+	"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512,
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// JWTConfig config of the JWT authenticator
+type JWTConfig struct {
+	// Keys verification keys by the kid of the token header, the key of the tokens without
+	// kid is "". A key is []byte for HS*, *rsa.PublicKey for RS* or *ecdsa.PublicKey for ES*.
+	Keys     map[string]interface{}
+	Issuer   string        // required iss, any issuer is accepted if it is empty
+	Audience string        // required aud, any audience is accepted if it is empty
+	Leeway   time.Duration // clock skew allowed when checking exp and nbf
+}
+
+type jwtAuthenticator struct {
+	config JWTConfig
+}
+
+// NewJWTAuthenticator authenticates the bearer JWTs signed with the local keys,
+// the sub claim is the subject of the principal and the roles claim its roles
+func NewJWTAuthenticator(config JWTConfig) Authenticator {
+	return &jwtAuthenticator{config: config}
+}
+
+type jwtHeader struct {
+	Alg  string          `json:"alg"`
+	Kid  string          `json:"kid"`
+	Crit json.RawMessage `json:"crit"` // 不支持任何扩展，存在即拒绝
+}
+
+// Authenticate .
+func (a *jwtAuthenticator) Authenticate(_ context.Context, md map[string]string) (*Principal, error) {
+	token, err := BearerToken(md)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	var header jwtHeader
+	if err = decodeSegment(parts[0], &header); err != nil || header.Crit != nil {
+		return nil, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	key, ok := a.config.Keys[header.Kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if err = verifyJWT(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err = a.verifyClaims(claims); err != nil {
+		return nil, err
+	}
+	subject, _ := claims["sub"].(string)
+	return &Principal{Subject: subject, Roles: stringsClaim(claims["roles"]), Claims: claims}, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// verifyJWT checks the signature of the signing input, the key must match the alg
+func verifyJWT(alg string, key interface{}, input string, sig []byte) error {
+	hash, ok := jwtAlgs[alg]
+	if !ok {
+		return ErrUnsupportedAlg
+	}
+	h := hash.New()
+	h.Write([]byte(input))
+	digest := h.Sum(nil)
+
+	var valid bool
+	switch k := key.(type) {
+	case []byte:
+		if alg[:2] != "HS" {
+			return ErrUnknownKey
+		}
+		mac := hmac.New(hash.New, k)
+		mac.Write([]byte(input))
+		valid = hmac.Equal(sig, mac.Sum(nil))
+	case *rsa.PublicKey:
+		if alg[:2] != "RS" {
+			return ErrUnknownKey
+		}
+		valid = rsa.VerifyPKCS1v15(k, hash, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(sig) != 2*size {
+			return ErrInvalidToken
+		}
+		// JWS 的 ECDSA 签名为定长的 r || s
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		valid = ecdsa.Verify(k, digest, r, s)
+	default:
+		return ErrUnknownKey
+	}
+	if !valid {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func (a *jwtAuthenticator) verifyClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	exp, ok, err := numericDate(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if ok && !now.Before(exp.Add(a.config.Leeway)) {
+		return ErrTokenExpired
+	}
+	nbf, ok, err := numericDate(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(a.config.Leeway).Before(nbf) {
+		return ErrInvalidToken
+	}
+	if a.config.Issuer != "" && claims["iss"] != a.config.Issuer {
+		return ErrInvalidToken
+	}
+	if a.config.Audience != "" && !hasAudience(claims["aud"], a.config.Audience) {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// numericDate returns false if the claim is absent, a claim that is not a number is invalid
+func numericDate(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok { // 包括 null
+		return time.Time{}, false, ErrInvalidToken
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, ErrInvalidToken
+	}
+	return time.Unix(int64(f), 0), true, nil
+}
+
+// hasAudience aud is a string or an array of strings
+func hasAudience(aud interface{}, audience string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == audience
+	case []interface{}:
+		for _, v := range a {
+			if v == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stringsClaim returns the strings of an array claim
+func stringsClaim(v interface{}) []string {
+	items, _ := v.([]interface{})
+	var out []string
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
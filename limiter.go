@@ -0,0 +1,170 @@
+package tinyrpc
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+	"tinyrpc/status"
+)
+
+// PriorityKey the request metadata key of the priority class of a call
+const PriorityKey = "priority"
+
+// Priority classes of the calls, the calls of lower classes are shed first
+const (
+	PrioritySheddable = "sheddable"
+	PriorityCritical  = "critical"
+)
+
+// priorityShare 各优先级可使用的并发上限比例，critical 可以略微超过上限
+var priorityShare = map[string]float64{
+	PrioritySheddable: 0.8,
+	PriorityCritical:  1.2,
+}
+
+// Classifier returns the priority class of a call, "" is the default class
+type Classifier func(ctx context.Context, info *CallInfo) string
+
+// AdaptiveLimit config of the adaptive concurrency limit. The limit grows by one every
+// limit calls while the latency stays under Tolerance times the no-load latency, and is
+// multiplied by Backoff after a slower call (AIMD). The latency of a call is measured
+// from the time its request is read, so the time queued in the server is included.
+type AdaptiveLimit struct {
+	Initial   int           // initial limit, 20 by default
+	Min       int           // 1 by default
+	Max       int           // 1000 by default
+	Tolerance float64       // 2 by default
+	Backoff   float64       // 0.9 by default
+	Window    int           // calls of each no-load latency window, 100 by default
+	Timeout   time.Duration // calls slower than Timeout always back off, 0 disables it
+	Classify  Classifier    // PrincipalPriority by default
+}
+
+// WithAdaptiveLimit caps the in-flight calls of the server with a limit adapted to the
+// observed latency, calls beyond the limit are rejected with status.Unavailable.
+// The Classify of the config selects the class of a call: sheddable calls are shed at
+// 80% of the limit and critical calls may exceed it by 20%.
+func WithAdaptiveLimit(config AdaptiveLimit) Option {
+	return func(o *options) {
+		o.adaptiveLimit = &config
+	}
+}
+
+// PrincipalPriority classifies the calls by the PriorityKey metadata of authenticated
+// callers, the metadata of anonymous callers is ignored
+func PrincipalPriority(ctx context.Context, _ *CallInfo) string {
+	if _, ok := PrincipalFromContext(ctx); !ok {
+		return ""
+	}
+	md, _ := MetadataFromContext(ctx)
+	return md[PriorityKey]
+}
+
+type adaptiveLimiter struct {
+	config AdaptiveLimit
+
+	mu          sync.Mutex
+	limit       float64
+	inflight    int
+	noLoad      time.Duration // 无负载延迟，取最近窗口内的最小值
+	windowMin   time.Duration
+	samples     int
+	lastBackoff time.Time // 之前开始的调用变慢不再重复降低上限
+	now         func() time.Time
+}
+
+func newAdaptiveLimiter(config AdaptiveLimit) *adaptiveLimiter {
+	if config.Min <= 0 {
+		config.Min = 1
+	}
+	if config.Max <= 0 {
+		config.Max = 1000
+	}
+	if config.Initial <= 0 {
+		config.Initial = 20
+	}
+	if config.Tolerance <= 1 {
+		config.Tolerance = 2
+	}
+	if config.Backoff <= 0 || config.Backoff >= 1 {
+		config.Backoff = 0.9
+	}
+	if config.Window <= 0 {
+		config.Window = 100
+	}
+	if config.Classify == nil {
+		config.Classify = PrincipalPriority
+	}
+	l := &adaptiveLimiter{config: config, now: time.Now}
+	l.limit = l.clamp(float64(config.Initial))
+	return l
+}
+
+func (l *adaptiveLimiter) clamp(limit float64) float64 {
+	return math.Max(float64(l.config.Min), math.Min(limit, float64(l.config.Max)))
+}
+
+// current returns the current limit
+func (l *adaptiveLimiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// acquire admits a call of the priority class, it returns false if the call is shed
+func (l *adaptiveLimiter) acquire(priority string) (start time.Time, inflight int, ok bool) {
+	share, ok := priorityShare[priority]
+	if !ok {
+		share = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if float64(l.inflight) >= math.Max(1, math.Floor(l.limit*share)) {
+		return time.Time{}, 0, false
+	}
+	l.inflight++
+	return l.now(), l.inflight, true
+}
+
+// release records the latency of a call admitted at start with inflight calls
+func (l *adaptiveLimiter) release(start time.Time, inflight int) {
+	now := l.now()
+	rtt := now.Sub(start)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+
+	if l.windowMin == 0 || rtt < l.windowMin {
+		l.windowMin = rtt
+	}
+	if l.noLoad == 0 || rtt < l.noLoad {
+		l.noLoad = rtt
+	}
+	if l.samples++; l.samples >= l.config.Window { // 新窗口的最小延迟替换旧的，以适应负载变化
+		l.noLoad, l.windowMin, l.samples = l.windowMin, 0, 0
+	}
+
+	slow := float64(rtt) > float64(l.noLoad)*l.config.Tolerance ||
+		(l.config.Timeout > 0 && rtt > l.config.Timeout)
+	switch {
+	case slow && start.After(l.lastBackoff):
+		l.limit = l.clamp(l.limit * l.config.Backoff)
+		l.lastBackoff = now
+	case !slow && float64(inflight)*2 >= l.limit: // 只在上限被充分使用时增加
+		l.limit = l.clamp(l.limit + 1/l.limit)
+	}
+}
+
+// interceptor sheds the calls beyond the limit
+func (l *adaptiveLimiter) interceptor(ctx context.Context, info *CallInfo, next Handler) error {
+	start, inflight, ok := l.acquire(l.config.Classify(ctx, info))
+	if !ok {
+		return status.New(status.Unavailable, "server is overloaded")
+	}
+	if !info.received.IsZero() { // 包括在服务端排队的时间
+		start = info.received
+	}
+	defer l.release(start, inflight)
+	return next(ctx, info)
+}
@@ -0,0 +1,193 @@
+package tinyrpc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+	"tinyrpc/serializer"
+	"tinyrpc/status"
+	js "tinyrpc/test_gen/json"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock 手动推进的时钟
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func TestAdaptiveLimiter_AIMD(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	l := newAdaptiveLimiter(AdaptiveLimit{Initial: 4, Min: 2, Max: 6, Window: 1000})
+	l.now = clock.now
+
+	// 延迟稳定时，上限被充分使用则每轮增加 1
+	call := func(rtt time.Duration, n int) {
+		clock.t = clock.t.Add(time.Millisecond)
+		var starts []time.Time
+		var inflights []int
+		for i := 0; i < n; i++ {
+			start, inflight, ok := l.acquire("")
+			assert.True(t, ok)
+			starts, inflights = append(starts, start), append(inflights, inflight)
+		}
+		clock.t = clock.t.Add(rtt)
+		for i := range starts {
+			l.release(starts[i], inflights[i])
+		}
+	}
+	call(10*time.Millisecond, 4)
+	assert.InDelta(t, 4.71, l.limit, 0.01) // 4 + 1/4 + 1/4.25 + 1/4.49，第一个调用时利用率不足一半
+	for i := 0; i < 5; i++ {
+		call(10*time.Millisecond, l.current())
+	}
+	assert.Equal(t, 6.0, l.limit) // Max
+
+	// 利用率低时不增加
+	l.limit = 4
+	call(10*time.Millisecond, 1)
+	assert.Equal(t, 4.0, l.limit)
+
+	// 变慢后按 Backoff 降低，同时开始的调用只降低一次
+	call(50*time.Millisecond, 4)
+	assert.InDelta(t, 3.6, l.limit, 1e-9)
+	call(50*time.Millisecond, 3)
+	assert.InDelta(t, 3.24, l.limit, 1e-9)
+	for i := 0; i < 5; i++ {
+		call(50*time.Millisecond, l.current())
+	}
+	assert.Equal(t, 2.0, l.limit) // Min
+}
+
+func TestAdaptiveLimiter_Window(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	l := newAdaptiveLimiter(AdaptiveLimit{Initial: 10, Window: 2})
+	l.now = clock.now
+	sample := func(rtt time.Duration) {
+		start, inflight, _ := l.acquire("")
+		clock.t = clock.t.Add(rtt)
+		l.release(start, inflight)
+	}
+	sample(10 * time.Millisecond)
+	sample(30 * time.Millisecond)
+	assert.Equal(t, 10*time.Millisecond, l.noLoad)
+	// 新窗口的最小延迟替换旧的无负载延迟
+	sample(30 * time.Millisecond)
+	sample(40 * time.Millisecond)
+	assert.Equal(t, 30*time.Millisecond, l.noLoad)
+}
+
+func TestAdaptiveLimiter_Priority(t *testing.T) {
+	l := newAdaptiveLimiter(AdaptiveLimit{Initial: 10, Min: 10, Max: 10})
+	for i := 0; i < 8; i++ {
+		_, _, ok := l.acquire(PrioritySheddable)
+		assert.True(t, ok)
+	}
+	_, _, ok := l.acquire(PrioritySheddable)
+	assert.False(t, ok)
+	for i := 0; i < 2; i++ {
+		_, _, ok = l.acquire("")
+		assert.True(t, ok)
+	}
+	_, _, ok = l.acquire("")
+	assert.False(t, ok)
+	for i := 0; i < 2; i++ {
+		_, _, ok = l.acquire(PriorityCritical)
+		assert.True(t, ok)
+	}
+	_, _, ok = l.acquire(PriorityCritical)
+	assert.False(t, ok)
+}
+
+func TestPrincipalPriority(t *testing.T) {
+	ctx := withMetadata(context.Background(), map[string]string{PriorityKey: PriorityCritical})
+	// 匿名调用方不能自行指定优先级
+	assert.Equal(t, "", PrincipalPriority(ctx, nil))
+	ctx = context.WithValue(ctx, principalKey{}, &Principal{Subject: "alice"})
+	assert.Equal(t, PriorityCritical, PrincipalPriority(ctx, nil))
+	assert.Equal(t, "", PrincipalPriority(context.WithValue(context.Background(), principalKey{}, &Principal{}), nil))
+}
+
+func TestAdaptiveLimiter_Received(t *testing.T) {
+	l := newAdaptiveLimiter(AdaptiveLimit{Initial: 10, Min: 1, Tolerance: 2})
+	next := func(context.Context, *CallInfo) error { return nil }
+	assert.Equal(t, nil, l.interceptor(context.Background(), &CallInfo{received: time.Now()}, next))
+	// 排队的时间计入延迟
+	info := &CallInfo{received: time.Now().Add(-time.Second)}
+	assert.Equal(t, nil, l.interceptor(context.Background(), info, next))
+	assert.Equal(t, 9, l.current())
+}
+
+func TestServer_AdaptiveLimit(t *testing.T) {
+	release := make(chan struct{})
+	var blocked sync.WaitGroup
+	block := func(ctx context.Context, info *CallInfo, next Handler) error {
+		if info.ServiceMethod == "ArithService.Mul" {
+			blocked.Done()
+			<-release
+		}
+		return next(ctx, info)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	server := NewServer(WithSerializer(serializer.NewJsonSerializer()),
+		WithAuthenticator(NewStaticAuthenticator(map[string]string{"token": "alice"})),
+		WithAdaptiveLimit(AdaptiveLimit{Initial: 5, Min: 5, Max: 5}), WithInterceptor(block))
+	assert.Equal(t, nil, server.Register(new(js.ArithService)))
+	go server.Serve(lis)
+
+	dial := func(priority string) *Client {
+		conn, err := net.Dial("tcp", lis.Addr().String())
+		assert.Equal(t, nil, err)
+		return NewClient(conn, WithSerializer(serializer.NewJsonSerializer()),
+			WithCredentials(credentialsFunc(func(string) (map[string]string, error) {
+				return map[string]string{PriorityKey: priority, AuthorizationKey: "Bearer token"}, nil
+			})))
+	}
+	normal, sheddable, critical := dial(""), dial(PrioritySheddable), dial(PriorityCritical)
+	defer normal.Close()
+	defer sheddable.Close()
+	defer critical.Close()
+
+	// 4 个调用占用并发：sheddable 已达到上限的 80%
+	blocked.Add(4)
+	calls := make([]chan *rpcCall, 0, 4)
+	for i := 0; i < 4; i++ {
+		calls = append(calls, goCall(normal, "ArithService.Mul"))
+	}
+	blocked.Wait()
+
+	add := func(c *Client) error {
+		return c.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, &js.ArithResponse{})
+	}
+	err = add(sheddable)
+	assert.Equal(t, status.Unavailable, status.Convert(err).Code())
+	assert.Equal(t, nil, add(normal))
+
+	blocked.Add(1)
+	calls = append(calls, goCall(normal, "ArithService.Mul"))
+	blocked.Wait()
+	err = add(normal)
+	assert.Equal(t, status.Unavailable, status.Convert(err).Code())
+	assert.Equal(t, nil, add(critical))
+
+	close(release)
+	for _, call := range calls {
+		assert.Equal(t, nil, (<-call).err)
+	}
+	assert.Equal(t, nil, add(sheddable))
+}
+
+type rpcCall struct{ err error }
+
+func goCall(c *Client, serviceMethod string) chan *rpcCall {
+	done := make(chan *rpcCall, 1)
+	go func() {
+		err := c.Call(serviceMethod, &js.ArithRequest{A: 20, B: 5}, &js.ArithResponse{})
+		done <- &rpcCall{err}
+	}()
+	return done
+}
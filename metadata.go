@@ -0,0 +1,65 @@
+package tinyrpc
+
+import (
+	"context"
+	"sync"
+)
+
+type metadataKey struct{}
+
+// MetadataFromContext returns the metadata of the request, it is available to
+// interceptors and handlers that take a context.Context
+func MetadataFromContext(ctx context.Context) (map[string]string, bool) {
+	md, ok := ctx.Value(metadataKey{}).(map[string]string)
+	return md, ok
+}
+
+func withMetadata(ctx context.Context, md map[string]string) context.Context {
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+type responseMetadataKey struct{}
+
+// responseMetadata 拦截器与 handler 设置的响应元数据
+type responseMetadata struct {
+	mu sync.Mutex
+	md map[string]string
+}
+
+func (m *responseMetadata) get() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.md
+}
+
+// SetResponseMetadata sets the metadata sent in the response header of the call, it
+// returns false if ctx is not the context of a call received by the server.
+// Clients receive it with Client.CallWithMetadata, and in the *ResponseError of a failed call.
+func SetResponseMetadata(ctx context.Context, key, value string) bool {
+	m, ok := ctx.Value(responseMetadataKey{}).(*responseMetadata)
+	if !ok {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.md == nil {
+		m.md = make(map[string]string)
+	}
+	m.md[key] = value
+	return true
+}
+
+// ResponseError is the error of a call whose response carries metadata, it unwraps
+// to the rpc.ServerError of the response
+type ResponseError struct {
+	Err      error
+	Metadata map[string]string
+}
+
+func (e *ResponseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,54 @@
+package tinyrpc
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"tinyrpc/codec"
+)
+
+// Peer is the client end of a connection accepted by the server, handlers get it
+// from the call context to call the services registered by Client.Register.
+// Calls are multiplexed over the same connection.
+type Peer struct {
+	*rpc.Client
+	notifier codec.Notifier
+	addr     net.Addr
+}
+
+func newPeer(cc rpc.ClientCodec, addr net.Addr) *Peer {
+	return &Peer{rpc.NewClientWithCodec(cc), cc.(codec.Notifier), addr}
+}
+
+// RemoteAddr returns the address of the client, nil if the connection is not a net.Conn
+func (p *Peer) RemoteAddr() net.Addr {
+	return p.addr
+}
+
+// Call synchronously calls the rpc function of the client
+func (p *Peer) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	return p.Client.Call(serviceMethod, args, reply)
+}
+
+// AsyncCall asynchronously calls the rpc function of the client and returns a channel of *rpc.Call
+func (p *Peer) AsyncCall(serviceMethod string, args interface{}, reply interface{}) chan *rpc.Call {
+	return p.Go(serviceMethod, args, reply, nil).Done
+}
+
+// Notify sends a one-way call to the client
+func (p *Peer) Notify(serviceMethod string, args interface{}) error {
+	return p.notifier.Notify(serviceMethod, args)
+}
+
+type peerKey struct{}
+
+// PeerFromContext returns the peer of the connection that the call comes from,
+// it is available to handlers that take a context.Context as the first argument
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
+
+func withPeer(ctx context.Context, p *Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, p)
+}
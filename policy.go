@@ -0,0 +1,228 @@
+package tinyrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+	"tinyrpc/status"
+)
+
+// defaultPolicyInterval 策略文件的默认检查间隔
+const defaultPolicyInterval = 5 * time.Second
+
+// Authorizer decides whether the caller of the context may call the service method
+type Authorizer interface {
+	Authorize(ctx context.Context, serviceMethod string) error
+}
+
+// WithAuthorizer authorizes every call after the Authenticator and before the
+// interceptors, calls that are not allowed are rejected with status.PermissionDenied
+func WithAuthorizer(a Authorizer) Option {
+	return func(o *options) {
+		o.authorizer = a
+	}
+}
+
+// authzInterceptor rejects the calls that a does not allow
+func authzInterceptor(a Authorizer) Interceptor {
+	return func(ctx context.Context, info *CallInfo, next Handler) error {
+		if err := a.Authorize(ctx, info.ServiceMethod); err != nil {
+			if s, ok := status.FromError(err); ok {
+				return s
+			}
+			return status.New(status.PermissionDenied, err.Error())
+		}
+		return next(ctx, info)
+	}
+}
+
+// Rule allows the subjects and the roles to call the methods. A method is a
+// "Service.Method" pattern of path.Match, e.g. "ArithService.*" or "*".
+// The subject "*" matches any authenticated caller.
+type Rule struct {
+	Subjects []string `json:"subjects"`
+	Roles    []string `json:"roles"`
+	Methods  []string `json:"methods"`
+}
+
+// Policy the access control list of the server, a call is denied unless a rule allows it.
+// The policy file is the JSON of Policy:
+//
+//	{"rules": [
+//		{"subjects": ["alice"], "methods": ["*"]},
+//		{"roles": ["reader"], "methods": ["ArithService.Add", "EchoService.*"]}
+//	]}
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// ParsePolicy parses the JSON of the policy and checks its patterns
+func ParsePolicy(data []byte) (*Policy, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	p := new(Policy)
+	if err := dec.Decode(p); err != nil {
+		return nil, err
+	}
+	for i, rule := range p.Rules {
+		for _, m := range rule.Methods {
+			if _, err := path.Match(m, ""); err != nil {
+				return nil, fmt.Errorf("rule %d: bad method pattern %q", i, m)
+			}
+		}
+	}
+	return p, nil
+}
+
+// LoadPolicy reads the policy file
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicy(data)
+}
+
+// Allow reports whether the caller with the subject and the roles may call the method
+func (p *Policy) Allow(subject string, roles []string, serviceMethod string) bool {
+	for _, rule := range p.Rules {
+		if rule.matchCaller(subject, roles) && rule.matchMethod(serviceMethod) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchCaller(subject string, roles []string) bool {
+	for _, s := range r.Subjects {
+		if subject != "" && (s == "*" || s == subject) {
+			return true
+		}
+	}
+	for _, want := range r.Roles {
+		for _, role := range roles {
+			if role == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchMethod(serviceMethod string) bool {
+	for _, m := range r.Methods {
+		if ok, _ := path.Match(m, serviceMethod); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize checks the caller of the context, the caller is the Principal of the
+// Authenticator, or the common name and the organizational units (as roles) of the
+// client certificate if the call is not authenticated by a token
+func (p *Policy) Authorize(ctx context.Context, serviceMethod string) error {
+	subject, roles := caller(ctx)
+	if p.Allow(subject, roles, serviceMethod) {
+		return nil
+	}
+	if subject == "" {
+		return status.New(status.PermissionDenied, "anonymous caller may not call "+serviceMethod)
+	}
+	return status.New(status.PermissionDenied, subject+" may not call "+serviceMethod)
+}
+
+func caller(ctx context.Context) (string, []string) {
+	if p, ok := PrincipalFromContext(ctx); ok {
+		return p.Subject, p.Roles
+	}
+	if id, ok := IdentityFromContext(ctx); ok {
+		return id.Subject.CommonName, id.Subject.OrganizationalUnit
+	}
+	return "", nil
+}
+
+// PolicyFile authorizes the calls by a policy file, the file is reloaded when it changes
+type PolicyFile struct {
+	file   string
+	policy atomic.Value // *Policy
+	size   int64
+	mtime  time.Time
+
+	once sync.Once
+	done chan struct{}
+}
+
+// NewPolicyFile loads the policy file and checks it for changes every interval,
+// 0 means every 5 seconds. A changed file that fails to load is logged and the
+// previous policy is kept.
+func NewPolicyFile(file string, interval time.Duration) (*PolicyFile, error) {
+	if interval <= 0 {
+		interval = defaultPolicyInterval
+	}
+	f := &PolicyFile{file: file, done: make(chan struct{})}
+	if _, err := f.reload(); err != nil {
+		return nil, err
+	}
+	go f.watch(interval)
+	return f, nil
+}
+
+// Policy returns the current policy
+func (f *PolicyFile) Policy() *Policy {
+	return f.policy.Load().(*Policy)
+}
+
+// Authorize .
+func (f *PolicyFile) Authorize(ctx context.Context, serviceMethod string) error {
+	return f.Policy().Authorize(ctx, serviceMethod)
+}
+
+// Close stops watching the policy file
+func (f *PolicyFile) Close() error {
+	f.once.Do(func() { close(f.done) })
+	return nil
+}
+
+func (f *PolicyFile) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			if reloaded, err := f.reload(); err != nil {
+				log.Printf("tinyrpc: reload policy %s failed: %v", f.file, err)
+			} else if reloaded {
+				log.Printf("tinyrpc: policy %s reloaded", f.file)
+			}
+		}
+	}
+}
+
+// reload loads the file if its size or modification time has changed
+func (f *PolicyFile) reload() (bool, error) {
+	info, err := os.Stat(f.file)
+	if err != nil {
+		return false, err
+	}
+	if f.policy.Load() != nil && info.Size() == f.size && info.ModTime().Equal(f.mtime) {
+		return false, nil
+	}
+	// 无论加载是否成功都记录文件状态，避免重复报告同一个错误
+	f.size, f.mtime = info.Size(), info.ModTime()
+	p, err := LoadPolicy(f.file)
+	if err != nil {
+		return false, err
+	}
+	f.policy.Store(p)
+	return true, nil
+}
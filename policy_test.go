@@ -0,0 +1,151 @@
+package tinyrpc
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"tinyrpc/serializer"
+	"tinyrpc/status"
+	js "tinyrpc/test_gen/json"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPolicy = `{"rules": [
+	{"subjects": ["alice"], "methods": ["*"]},
+	{"roles": ["reader"], "methods": ["ArithService.Add", "Echo*.Get?"]},
+	{"subjects": ["*"], "methods": ["HealthService.Check"]}
+]}`
+
+func TestPolicy_Allow(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	assert.Equal(t, nil, err)
+	cases := []struct {
+		name          string
+		subject       string
+		roles         []string
+		serviceMethod string
+		allow         bool
+	}{
+		{"test-1", "alice", nil, "ArithService.Div", true},
+		{"test-2", "bob", []string{"reader"}, "ArithService.Add", true},
+		{"test-3", "bob", []string{"reader"}, "ArithService.Div", false},
+		{"test-4", "bob", []string{"reader"}, "EchoService.GetA", true},
+		{"test-5", "bob", []string{"reader"}, "EchoService.GetAB", false},
+		{"test-6", "bob", nil, "HealthService.Check", true},
+		{"test-7", "bob", []string{"writer"}, "ArithService.Add", false},
+		{"test-8", "", []string{"reader"}, "ArithService.Add", true},
+		{"test-9", "", nil, "HealthService.Check", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.allow, p.Allow(c.subject, c.roles, c.serviceMethod))
+		})
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"test-1", `{"rules": [{"subjects": ["alice"], "methods": ["[a-"]}]}`, `rule 0: bad method pattern "[a-"`},
+		{"test-2", `{"rules": [{"users": ["alice"]}]}`, `json: unknown field "users"`},
+		{"test-3", `{"rules": `, "unexpected EOF"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := ParsePolicy([]byte(c.data))
+			assert.Equal(t, c.err, err.Error())
+		})
+	}
+}
+
+func TestPolicy_Authorize(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	assert.Equal(t, nil, err)
+
+	ctx := context.WithValue(context.Background(), principalKey{}, &Principal{Subject: "bob"})
+	err = p.Authorize(ctx, "ArithService.Add")
+	assert.Equal(t, status.PermissionDenied, status.Convert(err).Code())
+	assert.Equal(t, "bob may not call ArithService.Add", status.Convert(err).Message())
+
+	// 没有令牌时使用客户端证书的身份
+	ctx = context.WithValue(context.Background(), identityKey{}, &Identity{
+		Subject: pkix.Name{CommonName: "carol", OrganizationalUnit: []string{"reader"}}})
+	assert.Equal(t, nil, p.Authorize(ctx, "ArithService.Add"))
+
+	err = p.Authorize(context.Background(), "ArithService.Add")
+	assert.Equal(t, "anonymous caller may not call ArithService.Add", status.Convert(err).Message())
+}
+
+func TestPolicyFile_Reload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.json")
+	write := func(data string, mtime time.Time) {
+		assert.Equal(t, nil, os.WriteFile(file, []byte(data), 0600))
+		assert.Equal(t, nil, os.Chtimes(file, mtime, mtime))
+	}
+	now := time.Now()
+	write(`{"rules": [{"subjects": ["alice"], "methods": ["ArithService.Add"]}]}`, now)
+
+	f, err := NewPolicyFile(file, 10*time.Millisecond)
+	assert.Equal(t, nil, err)
+	defer f.Close()
+	assert.True(t, f.Policy().Allow("alice", nil, "ArithService.Add"))
+	assert.False(t, f.Policy().Allow("alice", nil, "ArithService.Div"))
+
+	write(`{"rules": [{"subjects": ["alice"], "methods": ["ArithService.*"]}]}`, now.Add(time.Second))
+	assert.Eventually(t, func() bool {
+		return f.Policy().Allow("alice", nil, "ArithService.Div")
+	}, time.Second, 10*time.Millisecond)
+
+	// 无效的策略不会替换当前策略
+	write(`{"rules": [`, now.Add(2*time.Second))
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, f.Policy().Allow("alice", nil, "ArithService.Div"))
+
+	_, err = NewPolicyFile(filepath.Join(t.TempDir(), "missing.json"), 0)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestServer_Authorizer(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	assert.Equal(t, nil, err)
+	tokens := NewStaticAuthenticator(map[string]string{"alice-token": "alice", "bob-token": "bob"})
+	roles := AuthenticatorFunc(func(ctx context.Context, md map[string]string) (*Principal, error) {
+		principal, err := tokens.Authenticate(ctx, md)
+		if err == nil && principal.Subject == "bob" {
+			principal.Roles = []string{"reader"}
+		}
+		return principal, err
+	})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	server := NewServer(WithSerializer(serializer.NewJsonSerializer()),
+		WithAuthenticator(roles), WithAuthorizer(p))
+	assert.Equal(t, nil, server.Register(new(js.ArithService)))
+	go server.Serve(lis)
+
+	dial := func(token string) *Client {
+		conn, err := net.Dial("tcp", lis.Addr().String())
+		assert.Equal(t, nil, err)
+		return NewClient(conn, WithSerializer(serializer.NewJsonSerializer()), WithCredentials(StaticToken(token)))
+	}
+	alice, bob := dial("alice-token"), dial("bob-token")
+	defer alice.Close()
+	defer bob.Close()
+
+	reply := &js.ArithResponse{}
+	assert.Equal(t, nil, alice.Call("ArithService.Div", &js.ArithRequest{A: 20, B: 5}, reply))
+	assert.Equal(t, float64(4), reply.C)
+	assert.Equal(t, nil, bob.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, reply))
+	assert.Equal(t, float64(25), reply.C)
+	err = bob.Call("ArithService.Div", &js.ArithRequest{A: 20, B: 5}, reply)
+	assert.Equal(t, status.PermissionDenied, status.Convert(err).Code())
+}
@@ -0,0 +1,69 @@
+package tinyrpc
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	errPoolFull   = errors.New("server request queue is full")
+	errPoolClosed = errors.New("server is closed")
+)
+
+// workerPool 固定数量的 worker 协程执行请求，正在处理与排队的请求数超过上限时拒绝提交
+type workerPool struct {
+	tasks chan func()
+	slots chan struct{} // 每个已提交且尚未 release 的请求占用一个
+
+	mu     sync.RWMutex // protect closed
+	closed bool
+}
+
+func newWorkerPool(workers, queueSize int) *workerPool {
+	p := &workerPool{
+		tasks: make(chan func(), workers+queueSize),
+		slots: make(chan struct{}, workers+queueSize),
+	}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) work() {
+	for task := range p.tasks {
+		task()
+	}
+}
+
+// submit queues task without blocking, it fails if all the workers are busy and the
+// queue is full or the pool is closed. The task calls release once its result is ready.
+func (p *workerPool) submit(task func()) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errPoolClosed
+	}
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		return errPoolFull
+	}
+	p.tasks <- task // 持有 slot 时 tasks 一定有空位
+	return nil
+}
+
+// release frees the slot of a submitted task
+func (p *workerPool) release() {
+	<-p.slots
+}
+
+// close stops the workers after the queued tasks are done
+func (p *workerPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.tasks)
+	}
+}
@@ -17,6 +17,11 @@ type rpc struct{}
 // Generate 生成自定义服务文件
 func (md *rpc) Generate(plugin *protogen.Plugin) error {
 	for _, file := range plugin.Files {
+		if file.Generate {
+			if err := generateValidators(plugin, file); err != nil {
+				return err
+			}
+		}
 		if len(file.Services) == 0 {
 			continue
 		}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"tinyrpc/validate"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var (
+	errorsNew         = protogen.GoIdent{GoName: "New", GoImportPath: "errors"}
+	fmtErrorf         = protogen.GoIdent{GoName: "Errorf", GoImportPath: "fmt"}
+	regexpMustCompile = protogen.GoIdent{GoName: "MustCompile", GoImportPath: "regexp"}
+	runeCount         = protogen.GoIdent{GoName: "RuneCountInString", GoImportPath: "unicode/utf8"}
+)
+
+// generateValidators 为带有校验规则（tinyrpc.validate.rules）的消息生成 Validate 方法
+func generateValidators(plugin *protogen.Plugin, file *protogen.File) error {
+	v := &validators{needs: make(map[protoreflect.FullName]bool)}
+	var messages []*protogen.Message
+	for _, m := range allMessages(file.Messages) {
+		if v.needsValidate(m) {
+			messages = append(messages, m)
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	g := plugin.NewGeneratedFile(file.GeneratedFilenamePrefix+".validate.go", file.GoImportPath)
+	g.P("// Code generated by protoc-gen-tinyrpc. DO NOT EDIT.")
+	g.P()
+	g.P("package ", file.GoPackageName)
+	for _, m := range messages {
+		g.P()
+		if err := v.generate(g, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allMessages returns the messages and their nested messages, map entries are skipped
+func allMessages(messages []*protogen.Message) []*protogen.Message {
+	var all []*protogen.Message
+	for _, m := range messages {
+		if m.Desc.IsMapEntry() {
+			continue
+		}
+		all = append(all, m)
+		all = append(all, allMessages(m.Messages)...)
+	}
+	return all
+}
+
+// fieldRules returns the validation rules of the field, nil if it has none
+func fieldRules(field *protogen.Field) *validate.FieldRules {
+	opts, ok := field.Desc.Options().(*descriptorpb.FieldOptions)
+	if !ok || opts == nil {
+		return nil
+	}
+	rules, _ := proto.GetExtension(opts, validate.E_Rules).(*validate.FieldRules)
+	return rules
+}
+
+type validators struct {
+	needs map[protoreflect.FullName]bool // messages that have a Validate method
+}
+
+// needsValidate reports whether the message or one of its message fields has rules
+func (v *validators) needsValidate(m *protogen.Message) bool {
+	if needs, ok := v.needs[m.Desc.FullName()]; ok {
+		return needs
+	}
+	v.needs[m.Desc.FullName()] = false // 递归引用自身时视为没有规则
+	needs := false
+	for _, f := range m.Fields {
+		if fieldRules(f) != nil || (f.Message != nil && !f.Desc.IsMap() && v.needsValidate(f.Message)) {
+			needs = true
+			break
+		}
+	}
+	v.needs[m.Desc.FullName()] = needs
+	return needs
+}
+
+func (v *validators) generate(g *protogen.GeneratedFile, m *protogen.Message) error {
+	var patterns []string
+	g.P("// Validate checks the field rules of ", m.GoIdent.GoName)
+	g.P("func (x *", m.GoIdent.GoName, ") Validate() error {")
+	for _, f := range m.Fields {
+		pattern, err := v.generateField(g, m, f)
+		if err != nil {
+			return err
+		}
+		if pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	g.P("return nil")
+	g.P("}")
+	for _, p := range patterns {
+		g.P()
+		g.P(p)
+	}
+	return nil
+}
+
+// generateField generates the checks of a field, it returns the declaration of the
+// regular expression of the field
+func (v *validators) generateField(g *protogen.GeneratedFile, m *protogen.Message, f *protogen.Field) (string, error) {
+	name := fmt.Sprintf("%s.%s", m.Desc.Name(), f.Desc.Name())
+	value := "x.Get" + f.GoName + "()"
+	invalid := func(format string, a ...interface{}) {
+		msg := strconv.Quote(fmt.Sprintf("invalid %s: ", name) + fmt.Sprintf(format, a...))
+		g.P("return ", errorsNew, "(", msg, ")")
+	}
+
+	rules := fieldRules(f)
+	if rules == nil {
+		rules = &validate.FieldRules{}
+	}
+	list := f.Desc.IsList() || f.Desc.IsMap()
+	kind := f.Desc.Kind()
+
+	// 有显式存在性的标量字段只在设置时检查取值
+	var set, unset string
+	if !list && kind != protoreflect.MessageKind && kind != protoreflect.GroupKind && f.Desc.HasPresence() {
+		if f.Oneof != nil && !f.Oneof.Desc.IsSynthetic() {
+			set = fmt.Sprintf("_, ok := x.Get%s().(*%s); ok", f.Oneof.GoName, g.QualifiedGoIdent(f.GoIdent))
+			unset = fmt.Sprintf("_, ok := x.Get%s().(*%s); !ok", f.Oneof.GoName, g.QualifiedGoIdent(f.GoIdent))
+		} else {
+			set = fmt.Sprintf("x.%s != nil", f.GoName)
+			unset = fmt.Sprintf("x.%s == nil", f.GoName)
+		}
+	}
+
+	if rules.Required {
+		switch {
+		case unset != "":
+			g.P("if ", unset, " {")
+		case list || kind == protoreflect.BytesKind:
+			g.P("if len(", value, ") == 0 {")
+		case kind == protoreflect.MessageKind || kind == protoreflect.GroupKind:
+			g.P("if ", value, " == nil {")
+		case kind == protoreflect.StringKind:
+			g.P("if ", value, ` == "" {`)
+		case kind == protoreflect.BoolKind:
+			g.P("if !", value, " {")
+		default:
+			g.P("if ", value, " == 0 {")
+		}
+		invalid("value is required")
+		g.P("}")
+		set = "" // 之后的检查只在字段已设置时执行
+	}
+
+	hasValueRules := rules.Min != nil || rules.Max != nil || rules.Pattern != "" ||
+		rules.MinLen != nil || rules.MaxLen != nil
+	if hasValueRules && set != "" {
+		g.P("if ", set, " {")
+	}
+
+	if rules.Min != nil || rules.Max != nil {
+		if list || !isNumeric(kind) {
+			return "", fmt.Errorf("%s: min and max only apply to numeric fields", f.Desc.FullName())
+		}
+		if rules.Min != nil && rules.Max != nil && *rules.Min > *rules.Max {
+			return "", fmt.Errorf("%s: min is greater than max", f.Desc.FullName())
+		}
+		if rules.Min != nil {
+			min, err := numericLiteral(kind, *rules.Min)
+			if err != nil {
+				return "", fmt.Errorf("%s: min %w", f.Desc.FullName(), err)
+			}
+			g.P("if ", value, " < ", min, " {")
+			invalid("value must be greater than or equal to %s", min)
+			g.P("}")
+		}
+		if rules.Max != nil {
+			max, err := numericLiteral(kind, *rules.Max)
+			if err != nil {
+				return "", fmt.Errorf("%s: max %w", f.Desc.FullName(), err)
+			}
+			g.P("if ", value, " > ", max, " {")
+			invalid("value must be less than or equal to %s", max)
+			g.P("}")
+		}
+	}
+
+	var pattern string
+	if rules.Pattern != "" {
+		if list || kind != protoreflect.StringKind {
+			return "", fmt.Errorf("%s: pattern only applies to string fields", f.Desc.FullName())
+		}
+		if _, err := regexp.Compile(rules.Pattern); err != nil {
+			return "", fmt.Errorf("%s: %w", f.Desc.FullName(), err)
+		}
+		re := fmt.Sprintf("_%s_%s_pattern", m.GoIdent.GoName, f.GoName)
+		pattern = fmt.Sprintf("var %s = %s(%s)", re, g.QualifiedGoIdent(regexpMustCompile),
+			strconv.Quote(rules.Pattern))
+		g.P("if !", re, ".MatchString(", value, ") {")
+		invalid("value does not match regex pattern %q", rules.Pattern)
+		g.P("}")
+	}
+
+	if rules.MinLen != nil || rules.MaxLen != nil {
+		length := "len(" + value + ")"
+		switch {
+		case list || kind == protoreflect.BytesKind:
+		case kind == protoreflect.StringKind:
+			length = g.QualifiedGoIdent(runeCount) + "(" + value + ")"
+		default:
+			return "", fmt.Errorf("%s: min_len and max_len only apply to strings, bytes, "+
+				"repeated fields and maps", f.Desc.FullName())
+		}
+		if rules.MinLen != nil && rules.MaxLen != nil && *rules.MinLen > *rules.MaxLen {
+			return "", fmt.Errorf("%s: min_len is greater than max_len", f.Desc.FullName())
+		}
+		if rules.MinLen != nil {
+			g.P("if ", length, " < ", *rules.MinLen, " {")
+			invalid("length must be at least %d", *rules.MinLen)
+			g.P("}")
+		}
+		if rules.MaxLen != nil {
+			g.P("if ", length, " > ", *rules.MaxLen, " {")
+			invalid("length must be at most %d", *rules.MaxLen)
+			g.P("}")
+		}
+	}
+
+	if hasValueRules && set != "" {
+		g.P("}")
+	}
+
+	// 嵌套消息的规则由其自身的 Validate 方法检查
+	if f.Message != nil && !f.Desc.IsMap() && v.needsValidate(f.Message) {
+		validator := "interface{ Validate() error }"
+		wrap := fmt.Sprintf("%q", "invalid "+name+": %w")
+		if f.Desc.IsList() {
+			g.P("for _, item := range ", value, " {")
+			g.P("if v, ok := interface{}(item).(", validator, "); ok {")
+		} else {
+			g.P("if v, ok := interface{}(", value, ").(", validator, "); ok && ", value, " != nil {")
+		}
+		g.P("if err := v.Validate(); err != nil {")
+		g.P("return ", fmtErrorf, "(", wrap, ", err)")
+		g.P("}")
+		g.P("}")
+		if f.Desc.IsList() {
+			g.P("}")
+		}
+	}
+	return pattern, nil
+}
+
+func isNumeric(kind protoreflect.Kind) bool {
+	switch kind {
+	case protoreflect.BoolKind, protoreflect.EnumKind, protoreflect.StringKind,
+		protoreflect.BytesKind, protoreflect.MessageKind, protoreflect.GroupKind:
+		return false
+	}
+	return true
+}
+
+// numericLiteral formats the rule value as a constant of the field type
+func numericLiteral(kind protoreflect.Kind, value float64) (string, error) {
+	var min, max float64 // max 不包含在取值范围内
+	switch kind {
+	case protoreflect.FloatKind:
+		if math.Abs(value) > math.MaxFloat32 {
+			return "", fmt.Errorf("%v overflows float", value)
+		}
+		return strconv.FormatFloat(value, 'g', -1, 64), nil
+	case protoreflect.DoubleKind:
+		return strconv.FormatFloat(value, 'g', -1, 64), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		min, max = math.MinInt32, math.MaxInt32+1
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		min, max = 0, math.MaxUint32+1
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		min, max = math.MinInt64, 1<<63
+	default: // uint64, fixed64
+		min, max = 0, 1<<64
+	}
+	if value != math.Trunc(value) || value < min || value >= max {
+		return "", fmt.Errorf("%v is not a valid %s", value, kind)
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64), nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"go/format"
+	"strings"
+	"testing"
+	"tinyrpc/validate"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func testField(name string, num int32, t descriptorpb.FieldDescriptorProto_Type,
+	rules *validate.FieldRules) *descriptorpb.FieldDescriptorProto {
+	f := &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(num),
+		Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:   t.Enum(),
+	}
+	if rules != nil {
+		f.Options = &descriptorpb.FieldOptions{}
+		proto.SetExtension(f.Options, validate.E_Rules, rules)
+	}
+	return f
+}
+
+// generate runs the plugin on a file with the messages
+func generate(messages ...*descriptorpb.DescriptorProto) *pluginpb.CodeGeneratorResponse {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:        proto.String("test.proto"),
+		Package:     proto.String("test"),
+		Syntax:      proto.String("proto3"),
+		Dependency:  []string{"validate/validate.proto"},
+		Options:     &descriptorpb.FileOptions{GoPackage: proto.String("tinyrpc/test;test")},
+		MessageType: messages,
+	}
+	req := &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"test.proto"},
+		ProtoFile: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto),
+			protodesc.ToFileDescriptorProto(validate.File_validate_validate_proto),
+			file,
+		},
+	}
+	plugin, err := protogen.Options{}.New(req)
+	if err != nil {
+		panic(err)
+	}
+	gen := rpc{}
+	if err := gen.Generate(plugin); err != nil {
+		plugin.Error(err)
+	}
+	return plugin.Response()
+}
+
+func TestGenerateValidators(t *testing.T) {
+	T := descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	user := &descriptorpb.DescriptorProto{
+		Name: proto.String("User"),
+		Field: []*descriptorpb.FieldDescriptorProto{
+			testField("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, &validate.FieldRules{
+				Required: true, Pattern: "^[a-z]+$", MaxLen: proto.Uint64(16)}),
+			testField("age", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, &validate.FieldRules{
+				Min: proto.Float64(0), Max: proto.Float64(150)}),
+			testField("score", 3, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, &validate.FieldRules{
+				Max: proto.Float64(0.5)}),
+			testField("note", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING, nil),
+		},
+	}
+	group := &descriptorpb.DescriptorProto{
+		Name: proto.String("Group"),
+		Field: []*descriptorpb.FieldDescriptorProto{
+			testField("owner", 1, T, &validate.FieldRules{Required: true}),
+			testField("members", 2, T, &validate.FieldRules{MinLen: proto.Uint64(1)}),
+		},
+	}
+	group.Field[0].TypeName = proto.String(".test.User")
+	group.Field[1].TypeName = proto.String(".test.User")
+	group.Field[1].Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	plain := &descriptorpb.DescriptorProto{
+		Name:  proto.String("Plain"),
+		Field: []*descriptorpb.FieldDescriptorProto{testField("a", 1, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, nil)},
+	}
+
+	resp := generate(user, group, plain)
+	assert.Equal(t, "", resp.GetError())
+	assert.Equal(t, 1, len(resp.File))
+	assert.Equal(t, "tinyrpc/test/test.validate.go", resp.File[0].GetName())
+	content := resp.File[0].GetContent()
+	_, err := format.Source([]byte(content))
+	assert.Equal(t, nil, err)
+
+	for _, s := range []string{
+		`func (x *User) Validate() error {`,
+		`if x.GetName() == "" {`,
+		`return errors.New("invalid User.name: value is required")`,
+		`if !_User_Name_pattern.MatchString(x.GetName()) {`,
+		`if utf8.RuneCountInString(x.GetName()) > 16 {`,
+		`if x.GetAge() < 0 {`,
+		`return errors.New("invalid User.age: value must be less than or equal to 150")`,
+		`if x.GetScore() > 0.5 {`,
+		`var _User_Name_pattern = regexp.MustCompile("^[a-z]+$")`,
+		`func (x *Group) Validate() error {`,
+		`if x.GetOwner() == nil {`,
+		`if len(x.GetMembers()) < 1 {`,
+		`for _, item := range x.GetMembers() {`,
+		`return fmt.Errorf("invalid Group.owner: %w", err)`,
+	} {
+		assert.True(t, strings.Contains(content, s), s)
+	}
+	assert.False(t, strings.Contains(content, "Plain"))
+	assert.False(t, strings.Contains(content, "GetNote"))
+}
+
+func TestGenerateValidators_Presence(t *testing.T) {
+	msg := &descriptorpb.DescriptorProto{
+		Name: proto.String("Query"),
+		Field: []*descriptorpb.FieldDescriptorProto{
+			testField("limit", 1, descriptorpb.FieldDescriptorProto_TYPE_UINT32, &validate.FieldRules{
+				Required: true, Max: proto.Float64(100)}),
+			testField("id", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64, &validate.FieldRules{
+				Min: proto.Float64(1)}),
+		},
+		OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("key")}, {Name: proto.String("_limit")}},
+	}
+	msg.Field[0].OneofIndex = proto.Int32(1)
+	msg.Field[0].Proto3Optional = proto.Bool(true)
+	msg.Field[1].OneofIndex = proto.Int32(0)
+
+	resp := generate(msg)
+	assert.Equal(t, "", resp.GetError())
+	content := resp.File[0].GetContent()
+	for _, s := range []string{
+		`if x.Limit == nil {`,
+		`if x.GetLimit() > 100 {`,
+		`if _, ok := x.GetKey().(*Query_Id); ok {`,
+		`if x.GetId() < 1 {`,
+	} {
+		assert.True(t, strings.Contains(content, s), s)
+	}
+	assert.False(t, strings.Contains(content, `if x.Limit != nil {`))
+}
+
+func TestGenerateValidators_InvalidRules(t *testing.T) {
+	cases := []struct {
+		name  string
+		t     descriptorpb.FieldDescriptorProto_Type
+		rules *validate.FieldRules
+		err   string
+	}{
+		{"test-1", descriptorpb.FieldDescriptorProto_TYPE_STRING, &validate.FieldRules{Min: proto.Float64(1)},
+			"test.M.f: min and max only apply to numeric fields"},
+		{"test-2", descriptorpb.FieldDescriptorProto_TYPE_INT32, &validate.FieldRules{Min: proto.Float64(0.5)},
+			"test.M.f: min 0.5 is not a valid int32"},
+		{"test-3", descriptorpb.FieldDescriptorProto_TYPE_UINT64, &validate.FieldRules{Max: proto.Float64(-1)},
+			"test.M.f: max -1 is not a valid uint64"},
+		{"test-4", descriptorpb.FieldDescriptorProto_TYPE_INT32,
+			&validate.FieldRules{Min: proto.Float64(2), Max: proto.Float64(1)}, "test.M.f: min is greater than max"},
+		{"test-5", descriptorpb.FieldDescriptorProto_TYPE_BYTES, &validate.FieldRules{Pattern: "a"},
+			"test.M.f: pattern only applies to string fields"},
+		{"test-6", descriptorpb.FieldDescriptorProto_TYPE_STRING, &validate.FieldRules{Pattern: "("},
+			"test.M.f: error parsing regexp: missing closing ): `(`"},
+		{"test-7", descriptorpb.FieldDescriptorProto_TYPE_INT32, &validate.FieldRules{MaxLen: proto.Uint64(1)},
+			"test.M.f: min_len and max_len only apply to strings, bytes, repeated fields and maps"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resp := generate(&descriptorpb.DescriptorProto{
+				Name:  proto.String("M"),
+				Field: []*descriptorpb.FieldDescriptorProto{testField("f", 1, c.t, c.rules)},
+			})
+			assert.Equal(t, c.err, resp.GetError())
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"tinyrpc/status"
+)
+
+// RetryAfterKey the response metadata key of the time in milliseconds a rate limited
+// caller should wait before retrying
+const RetryAfterKey = "retry-after-ms"
+
+// peerScanInterval 回收已满的调用方令牌桶的间隔
+const peerScanInterval = time.Minute
+
+// RateLimit allows Rate calls per second on average with bursts of up to Burst calls,
+// a zero Rate is unlimited. Burst is at least 1.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimits the token bucket limits of the server, a call must pass all of them
+type RateLimits struct {
+	Global  RateLimit            // all the calls of the server
+	Methods map[string]RateLimit // calls of each "Service.Method"
+	Peer    RateLimit            // calls of each caller
+	IP      RateLimit            // calls of each client IP, checked before authentication
+}
+
+// WithRateLimit limits the calls of the server, calls beyond the limits are rejected with
+// status.ResourceExhausted and the RetryAfterKey metadata. The IP limit is checked before
+// authentication, so unauthenticated floods do not reach the Authenticator, the other
+// limits after it. A caller is the subject of the Principal, the common name of the
+// client certificate or the IP of the connection.
+func WithRateLimit(limits RateLimits) Option {
+	return func(o *options) {
+		o.rateLimits = &limits
+	}
+}
+
+// RetryAfter returns the time to wait before retrying a call rejected by the rate limit
+// of the server, err is the error returned by Client.Call
+func RetryAfter(err error) (time.Duration, bool) {
+	var respErr *ResponseError
+	if !errors.As(err, &respErr) {
+		return 0, false
+	}
+	v, ok := respErr.Metadata[RetryAfterKey]
+	if !ok {
+		return 0, false
+	}
+	ms, perr := strconv.ParseInt(v, 10, 64)
+	if perr != nil {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// tokenBucket 令牌桶，tokens 按 rate 持续补充，最多 burst 个
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: limit.Rate, burst: burst, tokens: burst, last: now}
+}
+
+// take takes a token, it returns the time until a token is available if there is none
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// refund returns a token taken by a call that another limit rejected
+func (b *tokenBucket) refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.tokens+1, b.burst)
+}
+
+// full reports whether the bucket is full, a full bucket is the same as a new one
+func (b *tokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.tokens+now.Sub(b.last).Seconds()*b.rate, b.burst)
+		b.last = now
+	}
+}
+
+// bucketSet 每个调用方一个令牌桶，已满的令牌桶定期回收
+type bucketSet struct {
+	limit RateLimit
+
+	mu       sync.Mutex
+	buckets  map[string]*tokenBucket
+	lastScan time.Time
+}
+
+func newBucketSet(limit RateLimit, now time.Time) *bucketSet {
+	return &bucketSet{limit: limit, buckets: make(map[string]*tokenBucket), lastScan: now}
+}
+
+// get returns the bucket of key, the full buckets are removed once a minute
+func (s *bucketSet) get(key string, now time.Time) *tokenBucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastScan) >= peerScanInterval {
+		for k, b := range s.buckets {
+			if b.full(now) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastScan = now
+	}
+	b, ok := s.buckets[key]
+	if !ok {
+		b = newTokenBucket(s.limit, now)
+		s.buckets[key] = b
+	}
+	return b
+}
+
+type rateLimiter struct {
+	global  *tokenBucket
+	methods map[string]*tokenBucket
+	peers   *bucketSet // nil means unlimited
+	ips     *bucketSet // nil means unlimited
+}
+
+func newRateLimiter(limits RateLimits) *rateLimiter {
+	now := time.Now()
+	l := &rateLimiter{methods: make(map[string]*tokenBucket)}
+	if limits.Global.Rate > 0 {
+		l.global = newTokenBucket(limits.Global, now)
+	}
+	for method, limit := range limits.Methods {
+		if limit.Rate > 0 {
+			l.methods[method] = newTokenBucket(limit, now)
+		}
+	}
+	if limits.Peer.Rate > 0 {
+		l.peers = newBucketSet(limits.Peer, now)
+	}
+	if limits.IP.Rate > 0 {
+		l.ips = newBucketSet(limits.IP, now)
+	}
+	return l
+}
+
+// admit rejects the calls beyond the limit of the IP before authentication
+func (l *rateLimiter) admit(ctx context.Context, serviceMethod string) (context.Context, error) {
+	if l.ips == nil {
+		return ctx, nil
+	}
+	ip := ipOf(ctx)
+	if ip == "" {
+		return ctx, nil
+	}
+	now := time.Now()
+	if ok, wait := l.ips.get(ip, now).take(now); !ok {
+		return ctx, rateLimited(ctx, serviceMethod, wait)
+	}
+	return ctx, nil
+}
+
+// interceptor rejects the calls beyond the limits
+func (l *rateLimiter) interceptor(ctx context.Context, info *CallInfo, next Handler) error {
+	now := time.Now()
+	buckets := make([]*tokenBucket, 0, 3)
+	if l.global != nil {
+		buckets = append(buckets, l.global)
+	}
+	if b, ok := l.methods[info.ServiceMethod]; ok {
+		buckets = append(buckets, b)
+	}
+	if l.peers != nil {
+		if key := peerKeyOf(ctx); key != "" {
+			buckets = append(buckets, l.peers.get(key, now))
+		}
+	}
+	for i, b := range buckets {
+		if ok, wait := b.take(now); !ok {
+			for _, taken := range buckets[:i] {
+				taken.refund()
+			}
+			return rateLimited(ctx, info.ServiceMethod, wait)
+		}
+	}
+	return next(ctx, info)
+}
+
+// rateLimited sets the RetryAfterKey metadata and returns the status of a rejected call
+func rateLimited(ctx context.Context, serviceMethod string, wait time.Duration) error {
+	ms := (wait + time.Millisecond - 1) / time.Millisecond // 向上取整
+	SetResponseMetadata(ctx, RetryAfterKey, strconv.FormatInt(int64(ms), 10))
+	return status.New(status.ResourceExhausted, "rate limit exceeded for "+serviceMethod)
+}
+
+// peerKeyOf returns the caller of the context, "" if the caller is unknown
+func peerKeyOf(ctx context.Context) string {
+	if p, ok := PrincipalFromContext(ctx); ok && p.Subject != "" {
+		return "subject:" + p.Subject
+	}
+	if id, ok := IdentityFromContext(ctx); ok && id.Subject.CommonName != "" {
+		return "cn:" + id.Subject.CommonName
+	}
+	if ip := ipOf(ctx); ip != "" {
+		return "ip:" + ip
+	}
+	return ""
+}
+
+// ipOf returns the IP of the connection of the context, "" if it is unknown
+func ipOf(ctx context.Context) string {
+	p, ok := PeerFromContext(ctx)
+	if !ok || p.RemoteAddr() == nil {
+		return ""
+	}
+	addr := p.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return addr
+}
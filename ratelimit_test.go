@@ -0,0 +1,195 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tinyrpc/serializer"
+	"tinyrpc/status"
+	js "tinyrpc/test_gen/json"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(RateLimit{Rate: 2, Burst: 3}, now)
+	for i := 0; i < 3; i++ {
+		ok, _ := b.take(now)
+		assert.True(t, ok)
+	}
+	ok, wait := b.take(now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	ok, wait = b.take(now.Add(250 * time.Millisecond))
+	assert.False(t, ok)
+	assert.Equal(t, 250*time.Millisecond, wait)
+	ok, _ = b.take(now.Add(500 * time.Millisecond))
+	assert.True(t, ok)
+
+	b.refund()
+	ok, _ = b.take(now.Add(500 * time.Millisecond))
+	assert.True(t, ok)
+
+	// 令牌最多累积 burst 个
+	assert.False(t, b.full(now.Add(time.Second)))
+	assert.True(t, b.full(now.Add(2*time.Second)))
+	for i := 0; i < 3; i++ {
+		ok, _ = b.take(now.Add(time.Hour))
+		assert.True(t, ok)
+	}
+	ok, _ = b.take(now.Add(time.Hour))
+	assert.False(t, ok)
+}
+
+func TestRateLimiter_PeerBuckets(t *testing.T) {
+	l := newRateLimiter(RateLimits{Peer: RateLimit{Rate: 1, Burst: 1}})
+	now := time.Now()
+	a := l.peers.get("ip:10.0.0.1", now)
+	a.take(now)
+	l.peers.get("ip:10.0.0.2", now)
+	assert.Equal(t, a, l.peers.get("ip:10.0.0.1", now))
+	assert.Equal(t, 2, len(l.peers.buckets))
+
+	// 已满的令牌桶被回收
+	later := now.Add(peerScanInterval)
+	l.peers.buckets["ip:10.0.0.1"].take(later)
+	l.peers.get("ip:10.0.0.3", later)
+	assert.Equal(t, 2, len(l.peers.buckets))
+	_, ok := l.peers.buckets["ip:10.0.0.2"]
+	assert.False(t, ok)
+}
+
+func TestRetryAfter(t *testing.T) {
+	cases := []struct {
+		name  string
+		err   error
+		after time.Duration
+		ok    bool
+	}{
+		{"test-1", &ResponseError{rpc.ServerError("limited"), map[string]string{RetryAfterKey: "1500"}},
+			1500 * time.Millisecond, true},
+		{"test-2", &ResponseError{rpc.ServerError("limited"), map[string]string{RetryAfterKey: "x"}}, 0, false},
+		{"test-3", &ResponseError{rpc.ServerError("limited"), map[string]string{"other": "1"}}, 0, false},
+		{"test-4", rpc.ServerError("limited"), 0, false},
+		{"test-5", nil, 0, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			after, ok := RetryAfter(c.err)
+			assert.Equal(t, c.after, after)
+			assert.Equal(t, c.ok, ok)
+		})
+	}
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	server := NewServer(WithSerializer(serializer.NewJsonSerializer()),
+		WithAuthenticator(AuthenticatorFunc(func(ctx context.Context, md map[string]string) (*Principal, error) {
+			token, _ := BearerToken(md)
+			return &Principal{Subject: token}, nil // 无令牌的调用方按 IP 限流
+		})),
+		WithRateLimit(RateLimits{
+			Methods: map[string]RateLimit{"ArithService.Div": {Rate: 0.5, Burst: 2}},
+			Peer:    RateLimit{Rate: 0.5, Burst: 4},
+		}))
+	assert.Equal(t, nil, server.Register(new(js.ArithService)))
+	go server.Serve(lis)
+
+	dial := func(opts ...Option) *Client {
+		conn, err := net.Dial("tcp", lis.Addr().String())
+		assert.Equal(t, nil, err)
+		return NewClient(conn, append([]Option{WithSerializer(serializer.NewJsonSerializer())}, opts...)...)
+	}
+	alice := dial(WithCredentials(StaticToken("alice")))
+	defer alice.Close()
+	bob := dial(WithCredentials(StaticToken("bob")))
+	defer bob.Close()
+
+	div := func(c *Client) error {
+		return c.Call("ArithService.Div", &js.ArithRequest{A: 20, B: 5}, &js.ArithResponse{})
+	}
+	add := func(c *Client) error {
+		return c.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, &js.ArithResponse{})
+	}
+
+	// 方法限流由所有调用方共享
+	assert.Equal(t, nil, div(alice))
+	assert.Equal(t, nil, div(bob))
+	err = div(alice)
+	assert.Equal(t, status.ResourceExhausted, status.Convert(err).Code())
+	assert.Equal(t, "rate limit exceeded for ArithService.Div", status.Convert(err).Message())
+	after, ok := RetryAfter(err)
+	assert.True(t, ok)
+	assert.True(t, after > 0 && after <= 2*time.Second, after)
+	var serverErr rpc.ServerError
+	assert.True(t, errors.As(err, &serverErr))
+
+	// 被拒绝的调用不消耗调用方的令牌：alice 已用 1 个，还剩 3 个
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, nil, add(alice))
+	}
+	err = add(alice)
+	assert.Equal(t, status.ResourceExhausted, status.Convert(err).Code())
+	assert.Equal(t, nil, add(bob))
+
+	// 没有令牌时按 IP 限流
+	anonymous := dial()
+	defer anonymous.Close()
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, nil, add(anonymous))
+	}
+	_, ok = RetryAfter(add(anonymous))
+	assert.True(t, ok)
+}
+
+func TestServer_RateLimitIP(t *testing.T) {
+	var authenticated int32
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	server := NewServer(WithSerializer(serializer.NewJsonSerializer()),
+		WithAuthenticator(AuthenticatorFunc(func(ctx context.Context, md map[string]string) (*Principal, error) {
+			atomic.AddInt32(&authenticated, 1)
+			return nil, ErrMissingToken
+		})),
+		WithRateLimit(RateLimits{IP: RateLimit{Rate: 0.5, Burst: 2}}))
+	assert.Equal(t, nil, server.Register(new(js.ArithService)))
+	go server.Serve(lis)
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	assert.Equal(t, nil, err)
+	client := NewClient(conn, WithSerializer(serializer.NewJsonSerializer()))
+	defer client.Close()
+	add := func() error {
+		return client.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, &js.ArithResponse{})
+	}
+
+	// 超过 IP 限流的调用在认证之前被拒绝
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, status.Unauthenticated, status.Convert(add()).Code())
+	}
+	err = add()
+	assert.Equal(t, status.ResourceExhausted, status.Convert(err).Code())
+	_, ok := RetryAfter(err)
+	assert.True(t, ok)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&authenticated))
+
+	// 异步调用同样可以取得重试间隔
+	call := <-client.AsyncCall("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, &js.ArithResponse{})
+	after, ok := RetryAfter(call.Error)
+	assert.True(t, ok)
+	assert.True(t, after > 0 && after <= 2*time.Second, after)
+	args := &js.ArithRequest{A: 20, B: 5}
+	call = <-client.Go("ArithService.Add", args, &js.ArithResponse{}, make(chan *rpc.Call, 1)).Done
+	assert.Equal(t, args, call.Args)
+	_, ok = RetryAfter(call.Error)
+	assert.True(t, ok)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&authenticated))
+}
@@ -0,0 +1,53 @@
+package serializer
+
+import (
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CBORSerializer encodes messages as CBOR (RFC 8949), struct fields use the `cbor`
+// tag and fall back to the `json` tag
+type CBORSerializer struct {
+	enc cbor.EncMode
+	dec cbor.DecMode
+}
+
+// NewCBORSerializer create a CBOR serializer with preferred serialization, i.e.
+// integers, lengths and floats use their shortest form, map keys are not sorted.
+// time.Time is encoded as epoch-based date/time (tag 1).
+func NewCBORSerializer() Serializer {
+	return newCBORSerializer(cbor.PreferredUnsortedEncOptions(), cbor.DecOptions{})
+}
+
+// NewDeterministicCBORSerializer create a CBOR serializer with the Core Deterministic
+// Encoding of RFC 8949 section 4.2.1, so equal messages always encode to the same
+// bytes and can be signed. Map keys are sorted, indefinite lengths are not used,
+// and maps with duplicate keys are rejected when decoding.
+func NewDeterministicCBORSerializer() Serializer {
+	return newCBORSerializer(cbor.CoreDetEncOptions(),
+		cbor.DecOptions{DupMapKey: cbor.DupMapKeyEnforcedAPF})
+}
+
+func newCBORSerializer(encOpts cbor.EncOptions, decOpts cbor.DecOptions) *CBORSerializer {
+	// time.Time 编码为带 tag 1 的 epoch 时间，有小数部分时使用浮点数
+	encOpts.Time = cbor.TimeUnixDynamic
+	encOpts.TimeTag = cbor.EncTagRequired
+	enc, err := encOpts.EncMode()
+	if err != nil {
+		panic(err) // only returned for invalid options
+	}
+	dec, err := decOpts.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return &CBORSerializer{enc: enc, dec: dec}
+}
+
+// Marshal .
+func (s *CBORSerializer) Marshal(message interface{}) ([]byte, error) {
+	return s.enc.Marshal(message)
+}
+
+// Unmarshal .
+func (s *CBORSerializer) Unmarshal(data []byte, message interface{}) error {
+	return s.dec.Unmarshal(data, message)
+}
@@ -0,0 +1,216 @@
+package serializer
+
+import (
+	"encoding/hex"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+	js "tinyrpc/test_gen/json"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// rfc8949Encode examples of RFC 8949 Appendix A that have a deterministic encoding
+var rfc8949Encode = []struct {
+	diag  string
+	value interface{}
+	hex   string
+}{
+	{"0", 0, "00"},
+	{"1", 1, "01"},
+	{"10", 10, "0a"},
+	{"23", 23, "17"},
+	{"24", 24, "1818"},
+	{"25", 25, "1819"},
+	{"100", 100, "1864"},
+	{"1000", 1000, "1903e8"},
+	{"1000000", 1000000, "1a000f4240"},
+	{"1000000000000", int64(1000000000000), "1b000000e8d4a51000"},
+	{"18446744073709551615", uint64(18446744073709551615), "1bffffffffffffffff"},
+	{"-18446744073709551616", cbor.RawMessage{0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "3bffffffffffffffff"},
+	{"-1", -1, "20"},
+	{"-10", -10, "29"},
+	{"-100", -100, "3863"},
+	{"-1000", -1000, "3903e7"},
+	{"0.0", 0.0, "f90000"},
+	{"-0.0", math.Copysign(0, -1), "f98000"},
+	{"1.0", 1.0, "f93c00"},
+	{"1.1", 1.1, "fb3ff199999999999a"},
+	{"1.5", 1.5, "f93e00"},
+	{"65504.0", 65504.0, "f97bff"},
+	{"100000.0", 100000.0, "fa47c35000"},
+	{"3.4028234663852886e+38", 3.4028234663852886e+38, "fa7f7fffff"},
+	{"1.0e+300", 1.0e+300, "fb7e37e43c8800759c"},
+	{"5.960464477539063e-8", 5.960464477539063e-8, "f90001"},
+	{"0.00006103515625", 0.00006103515625, "f90400"},
+	{"-4.0", -4.0, "f9c400"},
+	{"-4.1", -4.1, "fbc010666666666666"},
+	{"Infinity", math.Inf(1), "f97c00"},
+	{"NaN", math.NaN(), "f97e00"},
+	{"-Infinity", math.Inf(-1), "f9fc00"},
+	{"false", false, "f4"},
+	{"true", true, "f5"},
+	{"null", nil, "f6"},
+	{"simple(16)", cbor.SimpleValue(16), "f0"},
+	{"simple(255)", cbor.SimpleValue(255), "f8ff"},
+	{"0(\"2013-03-21T20:04:00Z\")", cbor.Tag{Number: 0, Content: "2013-03-21T20:04:00Z"},
+		"c074323031332d30332d32315432303a30343a30305a"},
+	{"1(1363896240)", cbor.Tag{Number: 1, Content: 1363896240}, "c11a514b67b0"},
+	{"1(1363896240.5)", cbor.Tag{Number: 1, Content: 1363896240.5}, "c1fb41d452d9ec200000"},
+	{"2(h'010000000000000000')", new(big.Int).Lsh(big.NewInt(1), 64), "c249010000000000000000"},
+	{"23(h'01020304')", cbor.Tag{Number: 23, Content: []byte{1, 2, 3, 4}}, "d74401020304"},
+	{"24(h'6449455446')", cbor.Tag{Number: 24, Content: []byte("dIETF")}, "d818456449455446"},
+	{"32(\"http://www.example.com\")", cbor.Tag{Number: 32, Content: "http://www.example.com"},
+		"d82076687474703a2f2f7777772e6578616d706c652e636f6d"},
+	{"h''", []byte{}, "40"},
+	{"h'01020304'", []byte{1, 2, 3, 4}, "4401020304"},
+	{"\"\"", "", "60"},
+	{"\"a\"", "a", "6161"},
+	{"\"IETF\"", "IETF", "6449455446"},
+	{"\"\\\"\\\\\"", "\"\\", "62225c"},
+	{"\"\\u00fc\"", "\u00fc", "62c3bc"},
+	{"\"\\u6c34\"", "\u6c34", "63e6b0b4"},
+	{"\"\\ud800\\udd51\"", "\U00010151", "64f0908591"},
+	{"[]", []int{}, "80"},
+	{"[1, 2, 3]", []int{1, 2, 3}, "83010203"},
+	{"[1, [2, 3], [4, 5]]", []interface{}{1, []int{2, 3}, []int{4, 5}}, "8301820203820405"},
+	{"[1, 2, 3, ..., 25]", []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19,
+		20, 21, 22, 23, 24, 25}, "98190102030405060708090a0b0c0d0e0f101112131415161718181819"},
+	{"{}", map[string]int{}, "a0"},
+	{"{1: 2, 3: 4}", map[int]int{3: 4, 1: 2}, "a201020304"},
+	{"{\"a\": 1, \"b\": [2, 3]}", map[string]interface{}{"b": []int{2, 3}, "a": 1}, "a26161016162820203"},
+	{"[\"a\", {\"b\": \"c\"}]", []interface{}{"a", map[string]string{"b": "c"}}, "826161a161626163"},
+	{"{\"a\": \"A\", \"b\": \"B\", \"c\": \"C\", \"d\": \"D\", \"e\": \"E\"}",
+		map[string]string{"e": "E", "d": "D", "c": "C", "b": "B", "a": "A"},
+		"a56161614161626142616361436164614461656145"},
+}
+
+// rfc8949Reencode 解码后再次编码时与原编码不同的例子，时间统一编码为 tag 1
+var rfc8949Reencode = map[string]string{
+	"0(\"2013-03-21T20:04:00Z\")": "c11a514b67b0",
+}
+
+// rfc8949Decode examples of RFC 8949 Appendix A that use indefinite lengths, they
+// decode to the same value as the definite length encoding
+var rfc8949Decode = []struct {
+	diag  string
+	hex   string
+	value interface{}
+}{
+	{"(_ h'0102', h'030405')", "5f42010243030405ff", []byte{1, 2, 3, 4, 5}},
+	{"(_ \"strea\", \"ming\")", "7f657374726561646d696e67ff", "streaming"},
+	{"[_ ]", "9fff", []interface{}{}},
+	{"[_ 1, [2, 3], [_ 4, 5]]", "9f018202039f0405ffff",
+		[]interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}},
+	{"[1, [2, 3], [_ 4, 5]]", "83018202039f0405ff",
+		[]interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}},
+	{"[_ 1, 2, 3, ..., 25]", "9f0102030405060708090a0b0c0d0e0f101112131415161718181819ff",
+		[]interface{}{uint64(1), uint64(2), uint64(3), uint64(4), uint64(5), uint64(6), uint64(7),
+			uint64(8), uint64(9), uint64(10), uint64(11), uint64(12), uint64(13), uint64(14), uint64(15),
+			uint64(16), uint64(17), uint64(18), uint64(19), uint64(20), uint64(21), uint64(22), uint64(23),
+			uint64(24), uint64(25)}},
+	{"{_ \"a\": 1, \"b\": [_ 2, 3]}", "bf61610161629f0203ffff",
+		map[interface{}]interface{}{"a": uint64(1), "b": []interface{}{uint64(2), uint64(3)}}},
+	{"[\"a\", {_ \"b\": \"c\"}]", "826161bf61626163ff",
+		[]interface{}{"a", map[interface{}]interface{}{"b": "c"}}},
+	{"{_ \"Fun\": true, \"Amt\": -2}", "bf6346756ef563416d7421ff",
+		map[interface{}]interface{}{"Fun": true, "Amt": int64(-2)}},
+}
+
+func TestCBORSerializer_RFC8949(t *testing.T) {
+	s := NewDeterministicCBORSerializer()
+	for _, c := range rfc8949Encode {
+		t.Run(c.diag, func(t *testing.T) {
+			data, err := s.Marshal(c.value)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, c.hex, hex.EncodeToString(data))
+
+			// 解码后再次编码得到相同的字节
+			var v interface{}
+			assert.Equal(t, nil, s.Unmarshal(data, &v))
+			again, err := s.Marshal(v)
+			assert.Equal(t, nil, err)
+			expect, ok := rfc8949Reencode[c.diag]
+			if !ok {
+				expect = c.hex
+			}
+			assert.Equal(t, expect, hex.EncodeToString(again))
+		})
+	}
+	for _, c := range rfc8949Decode {
+		t.Run(c.diag, func(t *testing.T) {
+			data, _ := hex.DecodeString(c.hex)
+			var v interface{}
+			assert.Equal(t, nil, s.Unmarshal(data, &v))
+			assert.Equal(t, c.value, v)
+		})
+	}
+}
+
+type cborArg struct {
+	ID   int    `cbor:"1,keyasint"`
+	Name string `json:"name"`
+	Skip string `cbor:"-"`
+}
+
+func TestCBORSerializer_Marshal(t *testing.T) {
+	cases := []struct {
+		name       string
+		serializer Serializer
+		arg        interface{}
+		hex        string
+	}{
+		{
+			name:       "test-json-tag",
+			serializer: NewCBORSerializer(),
+			arg:        &js.ArithRequest{A: 1, B: 2},
+			hex:        "a26161f93c006162f94000",
+		},
+		{
+			name:       "test-omitempty",
+			serializer: NewCBORSerializer(),
+			arg:        &js.ArithResponse{},
+			hex:        "a0",
+		},
+		{
+			name:       "test-keyasint",
+			serializer: NewDeterministicCBORSerializer(),
+			arg:        cborArg{ID: 1, Name: "tiny", Skip: "skip"},
+			hex:        "a20101646e616d656474696e79",
+		},
+		{
+			name:       "test-deterministic-map",
+			serializer: NewDeterministicCBORSerializer(),
+			arg:        map[string]int{"bb": 2, "a": 1, "c": 3},
+			hex:        "a3616101616303626262" + "02",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := c.serializer.Marshal(c.arg)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, c.hex, hex.EncodeToString(data))
+		})
+	}
+}
+
+func TestCBORSerializer_Unmarshal(t *testing.T) {
+	s := NewCBORSerializer()
+	req := &js.ArithRequest{}
+	data, _ := hex.DecodeString("a26161f93c006162f94000")
+	assert.Equal(t, nil, s.Unmarshal(data, req))
+	assert.Equal(t, &js.ArithRequest{A: 1, B: 2}, req)
+
+	when := time.Time{}
+	data, _ = hex.DecodeString("c11a514b67b0")
+	assert.Equal(t, nil, s.Unmarshal(data, &when))
+	assert.Equal(t, int64(1363896240), when.Unix())
+
+	// 确定性模式拒绝重复的 map key
+	var m map[string]int
+	data, _ = hex.DecodeString("a2616101616102")
+	assert.Equal(t, nil, s.Unmarshal(data, &m))
+	assert.NotEqual(t, nil, NewDeterministicCBORSerializer().Unmarshal(data, &m))
+}
@@ -0,0 +1,69 @@
+package serializer
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sync"
+)
+
+// GobSerializer encodes messages with encoding/gob like the default codec of net/rpc.
+// Codecs use a session per connection, so the type information of a message is sent
+// only with the first message of its type in each direction.
+type GobSerializer struct{}
+
+func NewGobSerializer() Serializer {
+	return &GobSerializer{}
+}
+
+// Marshal encodes message with its type information
+func (*GobSerializer) Marshal(message interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(message); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes a message encoded by Marshal
+func (*GobSerializer) Unmarshal(data []byte, message interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(message)
+}
+
+// NewSession create a session that keeps the gob encoder and decoder of a connection
+func (*GobSerializer) NewSession() Serializer {
+	s := &gobSession{}
+	s.enc = gob.NewEncoder(&s.encBuf)
+	s.dec = gob.NewDecoder(&s.decBuf)
+	return s
+}
+
+// gobSession 连接上写出的消息组成一个 gob 流，读入的消息组成另一个 gob 流
+type gobSession struct {
+	encMu  sync.Mutex
+	encBuf bytes.Buffer
+	enc    *gob.Encoder
+
+	decMu  sync.Mutex
+	decBuf bytes.Buffer
+	dec    *gob.Decoder
+}
+
+// Marshal encodes message, the type information is only included the first time
+// a type is encoded by the session
+func (s *gobSession) Marshal(message interface{}) ([]byte, error) {
+	s.encMu.Lock()
+	defer s.encMu.Unlock()
+	s.encBuf.Reset()
+	if err := s.enc.Encode(message); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), s.encBuf.Bytes()...), nil
+}
+
+// Unmarshal decodes the next message of the stream, a nil message is discarded
+func (s *gobSession) Unmarshal(data []byte, message interface{}) error {
+	s.decMu.Lock()
+	defer s.decMu.Unlock()
+	s.decBuf.Write(data)
+	return s.dec.Decode(message)
+}
@@ -0,0 +1,42 @@
+package serializer
+
+import (
+	"testing"
+	js "tinyrpc/test_gen/json"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGobSerializer(t *testing.T) {
+	s := NewGobSerializer()
+	data, err := s.Marshal(&js.ArithRequest{A: 1, B: 2})
+	assert.Equal(t, nil, err)
+
+	req := &js.ArithRequest{}
+	assert.Equal(t, nil, s.Unmarshal(data, req))
+	assert.Equal(t, &js.ArithRequest{A: 1, B: 2}, req)
+}
+
+func TestGobSerializer_NewSession(t *testing.T) {
+	s := NewGobSerializer().(Stateful)
+	enc, dec := s.NewSession(), s.NewSession()
+
+	first, err := enc.Marshal(&js.ArithRequest{A: 1, B: 2})
+	assert.Equal(t, nil, err)
+	second, err := enc.Marshal(&js.ArithRequest{A: 3, B: 4})
+	assert.Equal(t, nil, err)
+	third, err := enc.Marshal(&js.ArithResponse{C: 5})
+	assert.Equal(t, nil, err)
+
+	// 类型信息只随第一个消息发送
+	assert.Less(t, len(second), len(first))
+	assert.NotEqual(t, nil, s.Unmarshal(second, &js.ArithRequest{}))
+
+	assert.Equal(t, nil, dec.Unmarshal(first, nil)) // discarded
+	req := &js.ArithRequest{}
+	assert.Equal(t, nil, dec.Unmarshal(second, req))
+	assert.Equal(t, &js.ArithRequest{A: 3, B: 4}, req)
+	resp := &js.ArithResponse{}
+	assert.Equal(t, nil, dec.Unmarshal(third, resp))
+	assert.Equal(t, &js.ArithResponse{C: 5}, resp)
+}
@@ -0,0 +1,48 @@
+package serializer
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgpackSerializer encodes plain Go structs as MessagePack, struct fields use the
+// `msgpack` tag and fall back to the `json` tag
+type MsgpackSerializer struct{}
+
+func NewMsgpackSerializer() Serializer {
+	return &MsgpackSerializer{}
+}
+
+// Marshal .
+func (s *MsgpackSerializer) Marshal(message interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := s.Encode(&buf, message); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal .
+func (s *MsgpackSerializer) Unmarshal(data []byte, message interface{}) error {
+	return s.Decode(bytes.NewReader(data), message)
+}
+
+// Encode writes the encoded message to w
+func (*MsgpackSerializer) Encode(w io.Writer, message interface{}) error {
+	enc := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(enc)
+	enc.Reset(w)
+	enc.SetCustomStructTag("json") // Reset 会清除自定义 tag
+	return enc.Encode(message)
+}
+
+// Decode reads a message from r
+func (*MsgpackSerializer) Decode(r io.Reader, message interface{}) error {
+	dec := msgpack.GetDecoder()
+	defer msgpack.PutDecoder(dec)
+	dec.Reset(r)
+	dec.SetCustomStructTag("json")
+	return dec.Decode(message)
+}
@@ -0,0 +1,92 @@
+package serializer
+
+import (
+	"bytes"
+	"testing"
+	js "tinyrpc/test_gen/json"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type msgpackArg struct {
+	ID    int    `msgpack:"id" json:"identifier"`
+	Name  string `json:"name,omitempty"`
+	Count int
+	Skip  string `msgpack:"-"`
+}
+
+func TestMsgpackSerializer_Marshal(t *testing.T) {
+	cases := []struct {
+		name string
+		arg  interface{}
+		data []byte
+	}{
+		{
+			name: "test-json-tag",
+			arg:  &js.ArithRequest{A: 1, B: 2},
+			// fixmap(2) "a" float64(1) "b" float64(2)
+			data: []byte{0x82, 0xa1, 0x61, 0xcb, 0x3f, 0xf0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+				0xa1, 0x62, 0xcb, 0x40, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0},
+		},
+		{
+			name: "test-omitempty",
+			arg:  &js.ArithResponse{},
+			data: []byte{0x80},
+		},
+		{
+			name: "test-msgpack-tag-first",
+			arg:  msgpackArg{ID: 1, Count: 2, Skip: "skip"},
+			// fixmap(2) "id" 1 "Count" 2
+			data: []byte{0x82, 0xa2, 0x69, 0x64, 0x1, 0xa5, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x2},
+		},
+		{
+			name: "test-nil",
+			arg:  nil,
+			data: []byte{0xc0},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := NewMsgpackSerializer().Marshal(c.arg)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, c.data, data)
+		})
+	}
+}
+
+func TestMsgpackSerializer_Unmarshal(t *testing.T) {
+	s := NewMsgpackSerializer()
+	data, err := s.Marshal(&js.ArithRequest{A: 1.5, B: -2})
+	assert.Equal(t, nil, err)
+
+	req := &js.ArithRequest{}
+	assert.Equal(t, nil, s.Unmarshal(data, req))
+	assert.Equal(t, &js.ArithRequest{A: 1.5, B: -2}, req)
+
+	arg := &msgpackArg{}
+	data, _ = s.Marshal(msgpackArg{ID: 1, Name: "tiny", Count: 2})
+	assert.Equal(t, nil, s.Unmarshal(data, arg))
+	assert.Equal(t, &msgpackArg{ID: 1, Name: "tiny", Count: 2}, arg)
+
+	assert.NotEqual(t, nil, s.Unmarshal([]byte{0xc1}, req))
+}
+
+func TestMsgpackSerializer_Stream(t *testing.T) {
+	s := NewMsgpackSerializer()
+	var buf bytes.Buffer
+	assert.Equal(t, nil, s.(Encoder).Encode(&buf, &js.ArithRequest{A: 1, B: 2}))
+	assert.Equal(t, nil, s.(Encoder).Encode(&buf, &js.ArithRequest{A: 3, B: 4}))
+
+	data, err := s.Marshal(&js.ArithRequest{A: 1, B: 2})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, data, buf.Bytes()[:len(data)])
+
+	// 每次解码一个消息
+	r := bytes.NewReader(buf.Bytes())
+	for _, expect := range []*js.ArithRequest{{A: 1, B: 2}, {A: 3, B: 4}} {
+		req := &js.ArithRequest{}
+		assert.Equal(t, nil, s.(Decoder).Decode(r, req))
+		assert.Equal(t, expect, req)
+	}
+	assert.Equal(t, 0, r.Len())
+}
@@ -41,3 +41,24 @@ func (*ProtoSerializer) Unmarshal(data []byte, message interface{}) error {
 
 	return proto.Unmarshal(data, body)
 }
+
+// MarshalAppend appends the encoded message to dst
+func (*ProtoSerializer) MarshalAppend(dst []byte, message interface{}) ([]byte, error) {
+	if message == nil {
+		return dst, nil
+	}
+	body, ok := message.(proto.Message)
+	if !ok {
+		return nil, ErrNotImplementProtoMessage
+	}
+	return proto.MarshalOptions{}.MarshalAppend(dst, body)
+}
+
+// Size returns the encoded size of message, 0 if it is not a proto.Message
+func (*ProtoSerializer) Size(message interface{}) int {
+	body, ok := message.(proto.Message)
+	if !ok {
+		return 0
+	}
+	return proto.Size(body)
+}
@@ -99,3 +99,37 @@ func TestProtoSerializer_Unmarshal(t *testing.T) {
 		})
 	}
 }
+
+func TestProtoSerializer_MarshalAppend(t *testing.T) {
+	s := NewProtoSerializer()
+	data, err := s.Marshal(&pb.ArithRequest{A: 1, B: 2})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(data), s.(Sizer).Size(&pb.ArithRequest{A: 1, B: 2}))
+	assert.Equal(t, 0, s.(Sizer).Size(testArg{}))
+
+	cases := []struct {
+		name   string
+		dst    []byte
+		arg    interface{}
+		expect []byte
+		err    error
+	}{
+		{"test-1", nil, &pb.ArithRequest{A: 1, B: 2}, data, nil},
+		{"test-2", []byte{0xff}, &pb.ArithRequest{A: 1, B: 2}, append([]byte{0xff}, data...), nil},
+		{"test-3", []byte{0xff}, nil, []byte{0xff}, nil},
+		{"test-4", nil, testArg{}, nil, ErrNotImplementProtoMessage},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out, err := s.(Appender).MarshalAppend(c.dst, c.arg)
+			assert.Equal(t, c.expect, out)
+			assert.Equal(t, c.err, err)
+		})
+	}
+
+	// 容量足够时不重新分配
+	dst := make([]byte, 0, len(data))
+	out, err := s.(Appender).MarshalAppend(dst, &pb.ArithRequest{A: 1, B: 2})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &dst[:1][0], &out[0])
+}
@@ -0,0 +1,75 @@
+package serializer
+
+import (
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// ProtoJSONSerializer encodes proto.Message as the canonical proto JSON mapping
+// (int64 as string, oneofs, well-known types), so JSON clients can call servers
+// whose handlers use the generated pb types
+type ProtoJSONSerializer struct {
+	marshal   protojson.MarshalOptions
+	unmarshal protojson.UnmarshalOptions
+}
+
+// ProtoJSONOption provides options for ProtoJSONSerializer
+type ProtoJSONOption func(s *ProtoJSONSerializer)
+
+// WithEmitUnpopulated emits the fields with default values, e.g. "a": 0
+func WithEmitUnpopulated() ProtoJSONOption {
+	return func(s *ProtoJSONSerializer) {
+		s.marshal.EmitUnpopulated = true
+	}
+}
+
+// WithProtoNames uses the field names of the .proto file instead of lowerCamelCase names,
+// both names are accepted when unmarshaling
+func WithProtoNames() ProtoJSONOption {
+	return func(s *ProtoJSONSerializer) {
+		s.marshal.UseProtoNames = true
+	}
+}
+
+// WithDiscardUnknown ignores unknown fields instead of returning an error
+func WithDiscardUnknown() ProtoJSONOption {
+	return func(s *ProtoJSONSerializer) {
+		s.unmarshal.DiscardUnknown = true
+	}
+}
+
+func NewProtoJSONSerializer(opts ...ProtoJSONOption) Serializer {
+	s := &ProtoJSONSerializer{}
+	for _, option := range opts {
+		option(s)
+	}
+	return s
+}
+
+// Marshal .
+func (s *ProtoJSONSerializer) Marshal(message interface{}) ([]byte, error) {
+	if message == nil {
+		return []byte{}, nil
+	}
+	body, ok := message.(proto.Message)
+	if !ok {
+		return nil, ErrNotImplementProtoMessage
+	}
+	return s.marshal.Marshal(body)
+}
+
+// Unmarshal .
+func (s *ProtoJSONSerializer) Unmarshal(data []byte, message interface{}) error {
+	if message == nil {
+		return nil
+	}
+	body, ok := message.(proto.Message)
+	if !ok {
+		return ErrNotImplementProtoMessage
+	}
+	if len(data) == 0 { // 与 proto 一致，空的 body 为空消息
+		proto.Reset(body)
+		return nil
+	}
+	return s.unmarshal.Unmarshal(data, body)
+}
@@ -0,0 +1,142 @@
+package serializer
+
+import (
+	"testing"
+	"time"
+	pb "tinyrpc/test_gen/message"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestProtoJSONSerializer_Marshal(t *testing.T) {
+	type expect struct {
+		data string
+		err  error
+	}
+	cases := []struct {
+		name   string
+		opts   []ProtoJSONOption
+		arg    interface{}
+		expect expect
+	}{
+		{
+			name:   "test-1",
+			arg:    &pb.ArithRequest{A: 1, B: 2},
+			expect: expect{data: `{"a":1,"b":2}`},
+		},
+		{
+			name:   "test-2",
+			arg:    &pb.ArithRequest{A: 1},
+			expect: expect{data: `{"a":1}`},
+		},
+		{
+			name:   "test-3",
+			opts:   []ProtoJSONOption{WithEmitUnpopulated()},
+			arg:    &pb.ArithRequest{A: 1},
+			expect: expect{data: `{"a":1,"b":0}`},
+		},
+		{
+			name:   "test-4",
+			arg:    &descriptorpb.FieldDescriptorProto{TypeName: proto.String(".pb.ArithRequest")},
+			expect: expect{data: `{"typeName":".pb.ArithRequest"}`},
+		},
+		{
+			name:   "test-5",
+			opts:   []ProtoJSONOption{WithProtoNames()},
+			arg:    &descriptorpb.FieldDescriptorProto{TypeName: proto.String(".pb.ArithRequest")},
+			expect: expect{data: `{"type_name":".pb.ArithRequest"}`},
+		},
+		{
+			name:   "test-6",
+			arg:    wrapperspb.Int64(1 << 60),
+			expect: expect{data: `"1152921504606846976"`},
+		},
+		{
+			name:   "test-7",
+			arg:    durationpb.New(1500 * time.Millisecond),
+			expect: expect{data: `"1.500s"`},
+		},
+		{
+			name:   "test-8",
+			arg:    testArg{},
+			expect: expect{err: ErrNotImplementProtoMessage},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := NewProtoJSONSerializer(c.opts...).Marshal(c.arg)
+			assert.Equal(t, c.expect.err, err)
+			if c.expect.err == nil {
+				assert.JSONEq(t, c.expect.data, string(data))
+			}
+		})
+	}
+}
+
+func TestProtoJSONSerializer_Unmarshal(t *testing.T) {
+	type expect struct {
+		message proto.Message
+		err     bool
+	}
+	cases := []struct {
+		name    string
+		opts    []ProtoJSONOption
+		arg     string
+		message proto.Message
+		expect  expect
+	}{
+		{
+			name:    "test-1",
+			arg:     `{"a":1,"b":2}`,
+			message: &pb.ArithRequest{},
+			expect:  expect{message: &pb.ArithRequest{A: 1, B: 2}},
+		},
+		{
+			name:    "test-2",
+			arg:     `{"type_name":".pb.ArithRequest"}`,
+			message: &descriptorpb.FieldDescriptorProto{},
+			expect: expect{message: &descriptorpb.FieldDescriptorProto{
+				TypeName: proto.String(".pb.ArithRequest")}},
+		},
+		{
+			name:    "test-3",
+			arg:     `"1152921504606846976"`,
+			message: &wrapperspb.Int64Value{},
+			expect:  expect{message: wrapperspb.Int64(1 << 60)},
+		},
+		{
+			name:    "test-4",
+			arg:     `{"a":1,"d":2}`,
+			message: &pb.ArithRequest{},
+			expect:  expect{err: true},
+		},
+		{
+			name:    "test-5",
+			opts:    []ProtoJSONOption{WithDiscardUnknown()},
+			arg:     `{"a":1,"d":2}`,
+			message: &pb.ArithRequest{},
+			expect:  expect{message: &pb.ArithRequest{A: 1}},
+		},
+		{
+			name:    "test-6",
+			arg:     ``,
+			message: &pb.ArithRequest{A: 1},
+			expect:  expect{message: &pb.ArithRequest{}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := NewProtoJSONSerializer(c.opts...).Unmarshal([]byte(c.arg), c.message)
+			assert.Equal(t, c.expect.err, err != nil)
+			if !c.expect.err {
+				assert.True(t, proto.Equal(c.expect.message, c.message))
+			}
+		})
+	}
+	assert.Equal(t, ErrNotImplementProtoMessage, NewProtoJSONSerializer().Unmarshal([]byte(`{}`), &testArg{}))
+	assert.Equal(t, nil, NewProtoJSONSerializer().Unmarshal([]byte(`{}`), nil))
+}
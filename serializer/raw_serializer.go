@@ -0,0 +1,69 @@
+package serializer
+
+import "errors"
+
+// ErrNotRawMessage refers to param that is neither RawMessage nor []byte
+var ErrNotRawMessage = errors.New("param is not RawMessage or []byte")
+
+// RawMessage is an encoded body. Codecs write and read it as is without calling the
+// serializer of the connection (except stateful serializers), so proxies and caching
+// layers can forward bodies without knowing their types.
+type RawMessage []byte
+
+// RawSerializer passes []byte and RawMessage through, the unmarshaled message
+// references the body read by the codec instead of copying it
+type RawSerializer struct{}
+
+func NewRawSerializer() Serializer {
+	return &RawSerializer{}
+}
+
+// Marshal .
+func (*RawSerializer) Marshal(message interface{}) ([]byte, error) {
+	if message == nil {
+		return []byte{}, nil
+	}
+	if data, ok := MarshalRaw(message); ok {
+		return data, nil
+	}
+	return nil, ErrNotRawMessage
+}
+
+// Unmarshal .
+func (*RawSerializer) Unmarshal(data []byte, message interface{}) error {
+	if message == nil {
+		return nil
+	}
+	if UnmarshalRaw(data, message) {
+		return nil
+	}
+	return ErrNotRawMessage
+}
+
+// MarshalRaw returns the bytes of RawMessage and []byte messages
+func MarshalRaw(message interface{}) ([]byte, bool) {
+	switch m := message.(type) {
+	case RawMessage:
+		return m, true
+	case *RawMessage:
+		return *m, true
+	case []byte:
+		return m, true
+	case *[]byte:
+		return *m, true
+	}
+	return nil, false
+}
+
+// UnmarshalRaw sets *RawMessage and *[]byte messages to data without copying
+func UnmarshalRaw(data []byte, message interface{}) bool {
+	switch m := message.(type) {
+	case *RawMessage:
+		*m = data
+		return true
+	case *[]byte:
+		*m = data
+		return true
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package serializer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawSerializer_Marshal(t *testing.T) {
+	type expect struct {
+		data []byte
+		err  error
+	}
+	raw := RawMessage{0x1, 0x2}
+	bytes := []byte{0x3}
+	cases := []struct {
+		name   string
+		arg    interface{}
+		expect expect
+	}{
+		{"test-1", raw, expect{data: []byte{0x1, 0x2}}},
+		{"test-2", &raw, expect{data: []byte{0x1, 0x2}}},
+		{"test-3", bytes, expect{data: []byte{0x3}}},
+		{"test-4", &bytes, expect{data: []byte{0x3}}},
+		{"test-5", nil, expect{data: []byte{}}},
+		{"test-6", "abc", expect{err: ErrNotRawMessage}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := NewRawSerializer().Marshal(c.arg)
+			assert.Equal(t, c.expect.data, data)
+			assert.Equal(t, c.expect.err, err)
+		})
+	}
+}
+
+func TestRawSerializer_Unmarshal(t *testing.T) {
+	data := []byte{0x1, 0x2}
+
+	var raw RawMessage
+	assert.Equal(t, nil, NewRawSerializer().Unmarshal(data, &raw))
+	assert.Equal(t, RawMessage(data), raw)
+	data[0] = 0x3 // 不拷贝 body
+	assert.Equal(t, byte(0x3), raw[0])
+
+	var bytes []byte
+	assert.Equal(t, nil, NewRawSerializer().Unmarshal(data, &bytes))
+	assert.Equal(t, data, bytes)
+
+	assert.Equal(t, nil, NewRawSerializer().Unmarshal(data, nil))
+	var s string
+	assert.Equal(t, ErrNotRawMessage, NewRawSerializer().Unmarshal(data, &s))
+}
@@ -1,7 +1,43 @@
 package serializer
 
+import "io"
+
 // Serializer 对函数传递参数进行序列化和反序列化
 type Serializer interface {
 	Marshal(message interface{}) ([]byte, error)
 	Unmarshal(data []byte, message interface{}) error
 }
+
+// Stateful is implemented by serializers whose encoding of a message depends on the
+// messages encoded before it, e.g. gob sends the type information only once.
+// Codecs create a session per connection and use it for the messages they write and
+// read: messages are encoded in the order they are written, and every message is
+// decoded in the order it is read, a nil message discards the decoded value.
+type Stateful interface {
+	Serializer
+	NewSession() Serializer
+}
+
+// Appender is implemented by serializers that can encode a message into an existing
+// buffer, codecs append the messages they write to pooled buffers
+type Appender interface {
+	MarshalAppend(dst []byte, message interface{}) ([]byte, error)
+}
+
+// Sizer is implemented by serializers that know the encoded size of a message
+// without encoding it, codecs use it to grow the buffer before MarshalAppend
+type Sizer interface {
+	Size(message interface{}) int
+}
+
+// Encoder is implemented by serializers that encode a message to a stream
+type Encoder interface {
+	Encode(w io.Writer, message interface{}) error
+}
+
+// Decoder is implemented by serializers that decode a message from a stream, codecs
+// decode the bodies that are neither compressed nor checksummed from the connection
+// without buffering them. r ends at the end of the body.
+type Decoder interface {
+	Decode(r io.Reader, message interface{}) error
+}
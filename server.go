@@ -1,17 +1,78 @@
 package tinyrpc
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
 	"log"
 	"net"
 	"net/rpc"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 	"tinyrpc/codec"
+	"tinyrpc/compressor"
 	"tinyrpc/serializer"
+	"tinyrpc/status"
 )
 
-// Server rpc server based on net/rpc implementation
+// A value sent as a placeholder for the server's response value when the server
+// receives an invalid request. It is never decoded by the client since the Response
+// contains an error when it is used.
+var invalidRequest = struct{}{}
+
+// WithWorkerPool set the number of server workers and the size of the server-wide
+// request queue, requests beyond them are rejected with status.ResourceExhausted.
+// By default every request runs in its own goroutine.
+func WithWorkerPool(workers, queueSize int) Option {
+	return func(o *options) {
+		o.workers = workers
+		o.queueSize = queueSize
+	}
+}
+
+// WithConnQueueLimit set the max number of in-flight requests per connection,
+// requests beyond it are rejected with status.ResourceExhausted
+func WithConnQueueLimit(limit int) Option {
+	return func(o *options) {
+		o.connQueueLimit = limit
+	}
+}
+
+// WithCrashOnPanic make a panic in an interceptor or a handler crash the process, by
+// default the panic is recovered and the call fails with status.Internal
+func WithCrashOnPanic() Option {
+	return func(o *options) {
+		o.crashOnPanic = true
+	}
+}
+
+// Server rpc server, requests are decoded by tinyrpc codec and dispatched to the
+// registered services in the same way as net/rpc
 type Server struct {
+	// Deprecated: requests are no longer dispatched by the net/rpc server, it only mirrors
+	// the registered services for callers of its ServeCodec, ServeConn and ServeHTTP.
 	*rpc.Server
+	// Deprecated: use WithSerializer, changing it after NewServer has no effect on
+	// the connections that are already served.
 	serializer.Serializer
+
+	serviceMap     sync.Map // map[string]*service
+	checksumKey    []byte
+	threshold      int // auto compression threshold of responses
+	policy         codec.CompressPolicy
+	sampler        *compressor.Sampler
+	pool           *workerPool // nil means one goroutine per request
+	connQueueLimit int
+	tlsConfig      *tls.Config
+	admissions     []admission // checks before the body is read
+	handler        Handler     // interceptors and dispatch
+	crashOnPanic   bool
+	panics         uint64 // recovered panics
 }
 
 // NewServer Create a new rpc server
@@ -22,27 +83,343 @@ func NewServer(opts ...Option) *Server {
 	for _, option := range opts {
 		option(&options)
 	}
-	return &Server{rpc.NewServer(), options.serializer}
+	s := &Server{
+		Server:         rpc.NewServer(),
+		Serializer:     options.serializer,
+		checksumKey:    options.checksumKey,
+		threshold:      options.compressThreshold,
+		policy:         options.compressPolicy,
+		sampler:        options.sampler,
+		connQueueLimit: options.connQueueLimit,
+		tlsConfig:      options.tlsConfig,
+		crashOnPanic:   options.crashOnPanic,
+	}
+	if options.workers > 0 {
+		s.pool = newWorkerPool(options.workers, options.queueSize)
+	}
+	var interceptors []Interceptor
+	if options.adaptiveLimit != nil { // 过载时在限流与授权之前拒绝
+		interceptors = append(interceptors, newAdaptiveLimiter(*options.adaptiveLimit).interceptor)
+	}
+	var limiter *rateLimiter
+	if options.rateLimits != nil { // 按 IP 限流在认证之前
+		limiter = newRateLimiter(*options.rateLimits)
+		s.admissions = append(s.admissions, limiter.admit)
+	}
+	if options.authenticator != nil {
+		s.admissions = append(s.admissions, authenticate(options.authenticator))
+	}
+	if limiter != nil {
+		interceptors = append(interceptors, limiter.interceptor)
+	}
+	if options.authorizer != nil {
+		interceptors = append(interceptors, authzInterceptor(options.authorizer))
+	}
+	s.handler = chain(append(interceptors, options.interceptors...), s.dispatch)
+	return s
 }
 
 // Register register rpc function
 func (s *Server) Register(rcvr interface{}) error {
-	return s.Server.Register(rcvr)
+	return s.register(rcvr, "", false)
 }
 
 // RegisterName register the rpc function with the specified name
 func (s *Server) RegisterName(name string, rcvr interface{}) error {
-	return s.Server.RegisterName(name, rcvr)
+	return s.register(rcvr, name, true)
+}
+
+func (s *Server) register(rcvr interface{}, name string, useName bool) error {
+	svc, err := newService(rcvr, name, useName)
+	if err != nil {
+		return err
+	}
+	if _, dup := s.serviceMap.LoadOrStore(svc.name, svc); dup {
+		return errors.New("rpc: service already defined: " + svc.name)
+	}
+	// 兼容直接使用 net/rpc 服务端的调用方，net/rpc 不支持带 context 的方法
+	if svc.hasPlainMethods() {
+		if useName {
+			s.Server.RegisterName(name, rcvr)
+		} else {
+			s.Server.Register(rcvr)
+		}
+	}
+	return nil
+}
+
+// Serve start service, connections are served over TLS if WithTLSConfig is set.
+// It returns the error of lis.Accept, e.g. once lis is closed.
+func (s *Server) Serve(lis net.Listener) error {
+	if s.tlsConfig != nil {
+		lis = tls.NewListener(lis, s.tlsConfig)
+	}
+	return s.serve(lis)
 }
 
-// Serve start service
-func (s *Server) Serve(lis net.Listener) {
+func (s *Server) serve(lis net.Listener) error {
 	log.Printf("tinyrpc started on: %s", lis.Addr().String())
+	var delay time.Duration // 临时错误的重试间隔，与 net/http 相同
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				log.Printf("tinyrpc: accept error: %v; retrying in %v", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn runs the server on a single connection, blocking until the client hangs up.
+// The TLS handshake of a *tls.Conn is completed first.
+func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	ctx := context.Background()
+	var addr net.Addr
+	if c, ok := conn.(net.Conn); ok {
+		addr = c.RemoteAddr()
+		var err error
+		if ctx, err = handshake(ctx, c); err != nil {
+			log.Printf("tinyrpc: TLS handshake with %s failed: %v", c.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+	}
+	cc := codec.NewServerCodec(conn, s.Serializer,
+		codec.WithChecksumKey(s.checksumKey),
+		codec.WithCompressThreshold(s.threshold),
+		codec.WithCompressPolicy(s.policy),
+		codec.WithSampler(s.sampler))
+	// the peer is shut down once the codec is closed
+	peer := newPeer(cc.(codec.Peer).PeerCodec(), addr)
+	s.serveCodec(withPeer(ctx, peer), cc)
+}
+
+// serveCodec reads requests in order and runs them concurrently. The codec must
+// support concurrent WriteResponse calls, which is true for tinyrpc codecs.
+func (s *Server) serveCodec(ctx context.Context, cc rpc.ServerCodec) {
+	wg := new(sync.WaitGroup)
+	var inflight int32 // requests of this connection that have not been responded
+	for {
+		svc, mtype, req, keepReading, err := s.readRequestHeader(cc)
+		received := time.Now()
+		if err != nil {
+			if !keepReading {
+				break
+			}
+			// discard body
+			cc.ReadRequestBody(nil)
+			// send a response if we actually managed to read a header.
+			s.sendResponse(cc, req, invalidRequest, err.Error())
 			continue
 		}
-		go s.Server.ServeCodec(codec.NewServerCodec(conn, s.Serializer))
+
+		callCtx := ctx
+		if md, ok := cc.(codec.RequestMetadata); ok {
+			if metadata := md.RequestMetadata(req.Seq); metadata != nil {
+				callCtx = withMetadata(ctx, metadata)
+			}
+		}
+		respMetadata := new(responseMetadata)
+		callCtx = context.WithValue(callCtx, responseMetadataKey{}, respMetadata)
+
+		// 被拒绝的调用不读取请求体，避免解压与解码未认证的数据
+		if callCtx, err = s.admit(callCtx, req.ServiceMethod); err != nil {
+			cc.ReadRequestBody(nil)
+			s.setResponseMetadata(cc, req, respMetadata)
+			s.sendResponse(cc, req, invalidRequest, err.Error())
+			continue
+		}
+		argv, replyv, err := s.readRequestBody(cc, mtype)
+		if err != nil {
+			s.sendResponse(cc, req, invalidRequest, err.Error())
+			continue
+		}
+
+		if s.connQueueLimit > 0 && atomic.LoadInt32(&inflight) >= int32(s.connQueueLimit) {
+			s.sendResponse(cc, req, invalidRequest,
+				status.New(status.ResourceExhausted, "connection request queue is full").Error())
+			continue
+		}
+		atomic.AddInt32(&inflight, 1)
+		wg.Add(1)
+		task := func() {
+			defer wg.Done()
+			info := &CallInfo{
+				ServiceMethod: req.ServiceMethod,
+				Args:          argv.Interface(),
+				Reply:         replyv.Interface(),
+				svc:           svc,
+				mtype:         mtype,
+				argv:          argv,
+				replyv:        replyv,
+				received:      received,
+			}
+			errmsg := ""
+			if err := s.handle(callCtx, info); err != nil {
+				errmsg = err.Error()
+			}
+			s.setResponseMetadata(cc, req, respMetadata)
+			// 先释放占用的名额，客户端收到响应后立即发起的请求不会被拒绝
+			atomic.AddInt32(&inflight, -1)
+			if s.pool != nil {
+				s.pool.release()
+			}
+			s.sendResponse(cc, req, replyv.Interface(), errmsg)
+		}
+		if s.pool == nil {
+			go task()
+		} else if err := s.pool.submit(task); err != nil {
+			atomic.AddInt32(&inflight, -1)
+			wg.Done()
+			code := status.ResourceExhausted
+			if err == errPoolClosed {
+				code = status.Unavailable
+			}
+			s.sendResponse(cc, req, invalidRequest, status.New(code, err.Error()).Error())
+		}
+	}
+	// We've seen that there are no more requests.
+	// Wait for responses to be sent before closing codec.
+	wg.Wait()
+	cc.Close()
+}
+
+// handle calls the handler, a panic is logged with its stack and returned as status.Internal
+// so the connection and the other calls are not affected
+func (s *Server) handle(ctx context.Context, info *CallInfo) (err error) {
+	if !s.crashOnPanic {
+		defer func() {
+			if r := recover(); r != nil {
+				atomic.AddUint64(&s.panics, 1)
+				log.Printf("tinyrpc: panic in %s: %v\n%s", info.ServiceMethod, r, debug.Stack())
+				err = status.New(status.Internal, "panic in "+info.ServiceMethod)
+			}
+		}()
+	}
+	return s.handler(ctx, info)
+}
+
+// admit runs the admissions of the call, a panic is returned as status.Internal
+func (s *Server) admit(ctx context.Context, serviceMethod string) (_ context.Context, err error) {
+	if !s.crashOnPanic {
+		defer func() {
+			if r := recover(); r != nil {
+				atomic.AddUint64(&s.panics, 1)
+				log.Printf("tinyrpc: panic in admission of %s: %v\n%s", serviceMethod, r, debug.Stack())
+				err = status.New(status.Internal, "panic in "+serviceMethod)
+			}
+		}()
+	}
+	for _, a := range s.admissions {
+		if ctx, err = a(ctx, serviceMethod); err != nil {
+			return ctx, err
+		}
+	}
+	return ctx, nil
+}
+
+// setResponseMetadata passes the response metadata set by the call to the codec
+func (s *Server) setResponseMetadata(cc rpc.ServerCodec, req *rpc.Request, md *responseMetadata) {
+	if c, ok := cc.(codec.ResponseMetadata); ok {
+		if metadata := md.get(); metadata != nil {
+			c.SetResponseMetadata(req.Seq, metadata)
+		}
+	}
+}
+
+// Close stops the workers of WithWorkerPool once the queued requests are done,
+// requests received after Close are rejected with status.Unavailable
+func (s *Server) Close() error {
+	if s.pool != nil {
+		s.pool.close()
+	}
+	return nil
+}
+
+// Panics returns the number of panics recovered by the server
+func (s *Server) Panics() uint64 {
+	return atomic.LoadUint64(&s.panics)
+}
+
+// dispatch validates the args and calls the handler of the service
+func (s *Server) dispatch(ctx context.Context, info *CallInfo) error {
+	if err := validate(info.argv); err != nil {
+		return err
+	}
+	return info.svc.call(ctx, info.mtype, info.argv, info.replyv)
+}
+
+func (s *Server) sendResponse(cc rpc.ServerCodec, req *rpc.Request, reply interface{}, errmsg string) {
+	resp := &rpc.Response{ServiceMethod: req.ServiceMethod, Seq: req.Seq}
+	// Encode the response header
+	if errmsg != "" {
+		resp.Error = errmsg
+		reply = invalidRequest
+	}
+	cc.WriteResponse(resp, reply)
+}
+
+// readRequestBody decodes the args of the request whose header was read
+func (s *Server) readRequestBody(cc rpc.ServerCodec, mtype *methodType) (argv, replyv reflect.Value, err error) {
+	// Decode the argument value.
+	argv, argIsValue := mtype.newArgv() // argv guaranteed to be a pointer now.
+	if err = cc.ReadRequestBody(argv.Interface()); err != nil {
+		return
+	}
+	if argIsValue {
+		argv = argv.Elem()
+	}
+	replyv = mtype.newReplyv()
+	return
+}
+
+func (s *Server) readRequestHeader(cc rpc.ServerCodec) (svc *service, mtype *methodType, req *rpc.Request,
+	keepReading bool, err error) {
+	// Grab the request header.
+	req = new(rpc.Request)
+	err = cc.ReadRequestHeader(req)
+	if err != nil {
+		req = nil
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = io.EOF
+			return
+		}
+		err = errors.New("rpc: server cannot decode request: " + err.Error())
+		return
+	}
+
+	// We read the header successfully. If we see an error now,
+	// we can still recover and move on to the next request.
+	keepReading = true
+
+	dot := strings.LastIndex(req.ServiceMethod, ".")
+	if dot < 0 {
+		err = errors.New("rpc: service/method request ill-formed: " + req.ServiceMethod)
+		return
+	}
+	serviceName := req.ServiceMethod[:dot]
+	methodName := req.ServiceMethod[dot+1:]
+
+	// Look up the request.
+	svci, ok := s.serviceMap.Load(serviceName)
+	if !ok {
+		err = errors.New("rpc: can't find service " + req.ServiceMethod)
+		return
+	}
+	svc = svci.(*service)
+	mtype = svc.method[methodName]
+	if mtype == nil {
+		err = errors.New("rpc: can't find method " + req.ServiceMethod)
 	}
+	return
 }
@@ -0,0 +1,145 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"go/token"
+	"reflect"
+)
+
+// Precompute the reflect type for error and context.
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+type methodType struct {
+	method      reflect.Method
+	ArgType     reflect.Type
+	ReplyType   reflect.Type
+	withContext bool // the first argument is the call context
+}
+
+// service 与 net/rpc 相同，保存注册对象及其满足 rpc 调用格式的方法
+type service struct {
+	name   string                 // name of service
+	rcvr   reflect.Value          // receiver of methods for the service
+	typ    reflect.Type           // type of the receiver
+	method map[string]*methodType // registered methods
+}
+
+func newService(rcvr interface{}, name string, useName bool) (*service, error) {
+	s := new(service)
+	s.typ = reflect.TypeOf(rcvr)
+	s.rcvr = reflect.ValueOf(rcvr)
+	sname := name
+	if !useName {
+		sname = reflect.Indirect(s.rcvr).Type().Name()
+	}
+	if sname == "" {
+		return nil, errors.New("rpc.Register: no service name for type " + s.typ.String())
+	}
+	if !useName && !token.IsExported(sname) {
+		return nil, errors.New("rpc.Register: type " + sname + " is not exported")
+	}
+	s.name = sname
+	s.method = suitableMethods(s.typ)
+	if len(s.method) == 0 {
+		return nil, errors.New("rpc.Register: type " + sname + " has no exported methods of suitable type")
+	}
+	return s, nil
+}
+
+// suitableMethods returns suitable rpc methods of typ, the method looks like:
+//
+//	func (t *T) MethodName(argType T1, replyType *T2) error
+//	func (t *T) MethodName(ctx context.Context, argType T1, replyType *T2) error
+func suitableMethods(typ reflect.Type) map[string]*methodType {
+	methods := make(map[string]*methodType)
+	for m := 0; m < typ.NumMethod(); m++ {
+		method := typ.Method(m)
+		mtype := method.Type
+		// Method must be exported.
+		if !method.IsExported() {
+			continue
+		}
+		// Method needs three ins: receiver, *args, *reply, the context is optional.
+		in := 1
+		withContext := mtype.NumIn() == 4 && mtype.In(1) == typeOfContext
+		if withContext {
+			in++
+		} else if mtype.NumIn() != 3 {
+			continue
+		}
+		argType := mtype.In(in)
+		if !isExportedOrBuiltinType(argType) {
+			continue
+		}
+		// Second arg must be a pointer and exported.
+		replyType := mtype.In(in + 1)
+		if replyType.Kind() != reflect.Pointer || !isExportedOrBuiltinType(replyType) {
+			continue
+		}
+		// Method needs one out, the error.
+		if mtype.NumOut() != 1 || mtype.Out(0) != typeOfError {
+			continue
+		}
+		methods[method.Name] = &methodType{method: method, ArgType: argType, ReplyType: replyType,
+			withContext: withContext}
+	}
+	return methods
+}
+
+// hasPlainMethods reports whether some methods don't take a context, only they are
+// supported by net/rpc
+func (s *service) hasPlainMethods() bool {
+	for _, m := range s.method {
+		if !m.withContext {
+			return true
+		}
+	}
+	return false
+}
+
+// Is this type exported or a builtin?
+func isExportedOrBuiltinType(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return token.IsExported(t.Name()) || t.PkgPath() == ""
+}
+
+// newArgv 创建参数对象，返回值 argIsValue 表示调用前需要解引用
+func (m *methodType) newArgv() (argv reflect.Value, argIsValue bool) {
+	if m.ArgType.Kind() == reflect.Pointer {
+		return reflect.New(m.ArgType.Elem()), false
+	}
+	return reflect.New(m.ArgType), true
+}
+
+func (m *methodType) newReplyv() reflect.Value {
+	replyv := reflect.New(m.ReplyType.Elem())
+	switch m.ReplyType.Elem().Kind() {
+	case reflect.Map:
+		replyv.Elem().Set(reflect.MakeMap(m.ReplyType.Elem()))
+	case reflect.Slice:
+		replyv.Elem().Set(reflect.MakeSlice(m.ReplyType.Elem(), 0, 0))
+	}
+	return replyv
+}
+
+func (s *service) call(ctx context.Context, mtype *methodType, argv, replyv reflect.Value) error {
+	function := mtype.method.Func
+	// Invoke the method, providing a new value for the reply.
+	var returnValues []reflect.Value
+	if mtype.withContext {
+		returnValues = function.Call([]reflect.Value{s.rcvr, reflect.ValueOf(ctx), argv, replyv})
+	} else {
+		returnValues = function.Call([]reflect.Value{s.rcvr, argv, replyv})
+	}
+	// The return value for the method is an error.
+	if errInter := returnValues[0].Interface(); errInter != nil {
+		return errInter.(error)
+	}
+	return nil
+}
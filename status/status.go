@@ -0,0 +1,151 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+	"strings"
+)
+
+// Code rpc status code, the values follow gRPC codes
+type Code uint32
+
+const (
+	OK Code = iota
+	Canceled
+	Unknown
+	InvalidArgument
+	DeadlineExceeded
+	NotFound
+	AlreadyExists
+	PermissionDenied
+	ResourceExhausted
+	FailedPrecondition
+	Aborted
+	OutOfRange
+	Unimplemented
+	Internal
+	Unavailable
+	DataLoss
+	Unauthenticated
+)
+
+var codeNames = [...]string{
+	OK:                 "OK",
+	Canceled:           "Canceled",
+	Unknown:            "Unknown",
+	InvalidArgument:    "InvalidArgument",
+	DeadlineExceeded:   "DeadlineExceeded",
+	NotFound:           "NotFound",
+	AlreadyExists:      "AlreadyExists",
+	PermissionDenied:   "PermissionDenied",
+	ResourceExhausted:  "ResourceExhausted",
+	FailedPrecondition: "FailedPrecondition",
+	Aborted:            "Aborted",
+	OutOfRange:         "OutOfRange",
+	Unimplemented:      "Unimplemented",
+	Internal:           "Internal",
+	Unavailable:        "Unavailable",
+	DataLoss:           "DataLoss",
+	Unauthenticated:    "Unauthenticated",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return fmt.Sprintf("Code(%d)", uint32(c))
+}
+
+// parseCode 将 String 的结果转换回 Code
+func parseCode(s string) (Code, bool) {
+	for c, name := range codeNames {
+		if name == s {
+			return Code(c), true
+		}
+	}
+	return Unknown, false
+}
+
+const (
+	prefix    = "rpc error: code = "
+	separator = " desc = "
+)
+
+// Status rpc call status, it is sent to the client as the error of the response
+type Status struct {
+	code    Code
+	message string
+}
+
+// New create a status
+func New(c Code, msg string) *Status {
+	return &Status{code: c, message: msg}
+}
+
+// Errorf create a status error with formatted message
+func Errorf(c Code, format string, a ...interface{}) error {
+	return New(c, fmt.Sprintf(format, a...))
+}
+
+// Code get status code
+func (s *Status) Code() Code {
+	if s == nil {
+		return OK
+	}
+	return s.code
+}
+
+// Message get status message
+func (s *Status) Message() string {
+	if s == nil {
+		return ""
+	}
+	return s.message
+}
+
+// Error encodes the status in the error string carried by the response header
+func (s *Status) Error() string {
+	return prefix + s.code.String() + separator + s.message
+}
+
+// FromError returns the status of err, err returned by server handlers and
+// the rpc.ServerError received by clients are both supported.
+// ok is false if err does not carry a status, the returned status is Unknown.
+func FromError(err error) (s *Status, ok bool) {
+	if err == nil {
+		return nil, true
+	}
+	if errors.As(err, &s) {
+		return s, true
+	}
+	var serverErr rpc.ServerError
+	if errors.As(err, &serverErr) {
+		if s, ok := parse(string(serverErr)); ok {
+			return s, true
+		}
+	}
+	return New(Unknown, err.Error()), false
+}
+
+// Convert is FromError without the ok flag
+func Convert(err error) *Status {
+	s, _ := FromError(err)
+	return s
+}
+
+func parse(msg string) (*Status, bool) {
+	if !strings.HasPrefix(msg, prefix) {
+		return nil, false
+	}
+	msg = msg[len(prefix):]
+	i := strings.Index(msg, separator)
+	if i < 0 {
+		return nil, false
+	}
+	c, ok := parseCode(msg[:i])
+	if !ok {
+		return nil, false
+	}
+	return New(c, msg[i+len(separator):]), true
+}
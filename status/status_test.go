@@ -0,0 +1,47 @@
+package status
+
+import (
+	"errors"
+	"net/rpc"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromError(t *testing.T) {
+	type expect struct {
+		code    Code
+		message string
+		ok      bool
+	}
+	cases := []struct {
+		name   string
+		err    error
+		expect expect
+	}{
+		{"test-nil", nil, expect{OK, "", true}},
+		{"test-status", Errorf(ResourceExhausted, "queue is full"), expect{ResourceExhausted, "queue is full", true}},
+		{"test-server-error",
+			rpc.ServerError("rpc error: code = InvalidArgument desc = a must be positive"),
+			expect{InvalidArgument, "a must be positive", true}},
+		{"test-plain-server-error", rpc.ServerError("divided is zero"), expect{Unknown, "divided is zero", false}},
+		{"test-unknown-code",
+			rpc.ServerError("rpc error: code = Foo desc = bar"),
+			expect{Unknown, "rpc error: code = Foo desc = bar", false}},
+		{"test-error", errors.New("shut down"), expect{Unknown, "shut down", false}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, ok := FromError(c.err)
+			assert.Equal(t, c.expect.ok, ok)
+			assert.Equal(t, c.expect.code, s.Code())
+			assert.Equal(t, c.expect.message, s.Message())
+		})
+	}
+}
+
+func TestStatus_Error(t *testing.T) {
+	err := Errorf(ResourceExhausted, "server is busy")
+	assert.Equal(t, "rpc error: code = ResourceExhausted desc = server is busy", err.Error())
+	assert.Equal(t, "Code(100)", Code(100).String())
+}
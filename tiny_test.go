@@ -1,14 +1,24 @@
 package tinyrpc
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
+	"io"
 	"log"
 	"net"
 	"net/rpc"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
+	"tinyrpc/checksum"
+	"tinyrpc/codec"
 	"tinyrpc/compressor"
+	"tinyrpc/header"
 	"tinyrpc/serializer"
+	"tinyrpc/status"
 	js "tinyrpc/test_gen/json"
 	pb "tinyrpc/test_gen/message"
 
@@ -28,6 +38,14 @@ func init() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	err = server.Register(new(NotifyService))
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = server.Register(new(PushService))
+	if err != nil {
+		log.Fatal(err)
+	}
 	go server.Serve(lis)
 
 	// json serializer
@@ -41,15 +59,259 @@ func init() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	err = server.Register(new(ValidateService))
+	if err != nil {
+		log.Fatal(err)
+	}
+	go server.Serve(lis)
+
+	// hmac checksum
+	lis, err = net.Listen("tcp", ":8010")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server = NewServer(WithChecksumKey([]byte("tinyrpc")))
+	err = server.Register(new(pb.ArithService))
+	if err != nil {
+		log.Fatal(err)
+	}
+	go server.Serve(lis)
+
+	// auto compression
+	lis, err = net.Listen("tcp", ":8013")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server = NewServer(WithCompressThreshold(64))
+	err = server.Register(new(pb.ArithService))
+	if err != nil {
+		log.Fatal(err)
+	}
+	go server.Serve(lis)
+
+	// response compress policy
+	lis, err = net.Listen("tcp", ":8014")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server = NewServer(WithCompressPolicy(codec.SizeCompress(1, compressor.Zstd)))
+	err = server.Register(new(pb.ArithService))
+	if err != nil {
+		log.Fatal(err)
+	}
+	go server.Serve(lis)
+
+	// dictionary sampler
+	lis, err = net.Listen("tcp", ":8015")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server = NewServer(WithDictSampler(dictSampler))
+	err = server.Register(new(pb.ArithService))
+	if err != nil {
+		log.Fatal(err)
+	}
+	go server.Serve(lis)
+
+	// msgpack serializer
+	lis, err = net.Listen("tcp", ":8016")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server = NewServer(WithSerializer(serializer.NewMsgpackSerializer()))
+	err = server.Register(new(js.ArithService))
+	if err != nil {
+		log.Fatal(err)
+	}
+	go server.Serve(lis)
+
+	// cbor serializer
+	lis, err = net.Listen("tcp", ":8017")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server = NewServer(WithSerializer(serializer.NewDeterministicCBORSerializer()))
+	err = server.Register(new(js.ArithService))
+	if err != nil {
+		log.Fatal(err)
+	}
+	go server.Serve(lis)
+
+	// gob serializer
+	lis, err = net.Listen("tcp", ":8018")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server = NewServer(WithSerializer(serializer.NewGobSerializer()))
+	err = server.Register(new(js.ArithService))
+	if err != nil {
+		log.Fatal(err)
+	}
+	go server.Serve(lis)
+
+	// protojson serializer, the handlers use the pb types
+	lis, err = net.Listen("tcp", ":8019")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server = NewServer(WithSerializer(serializer.NewProtoJSONSerializer()))
+	err = server.Register(new(pb.ArithService))
+	if err != nil {
+		log.Fatal(err)
+	}
+	go server.Serve(lis)
+
+	// raw passthrough proxy of the proto serializer server
+	lis, err = net.Listen("tcp", ":8020")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	upstream, err := net.Dial("tcp", ":8008")
+	if err != nil {
+		log.Fatal(err)
+	}
+	server = NewServer()
+	err = server.RegisterName("ArithService", &ProxyService{upstream: NewClient(upstream)})
+	if err != nil {
+		log.Fatal(err)
+	}
 	go server.Serve(lis)
 }
 
+// dictSampler captures the payloads of the server on :8015
+var dictSampler = compressor.NewSampler(100)
+
+// notified receives the args of NotifyService.Record
+var notified = make(chan *pb.ArithRequest, 1)
+
+// NotifyService records one-way calls
+type NotifyService struct{}
+
+func (*NotifyService) Record(args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	notified <- args
+	reply.C = args.A
+	return nil
+}
+
+// ProxyService forwards the request bodies to the upstream server without decoding them
+type ProxyService struct {
+	upstream *Client
+}
+
+func (p *ProxyService) Add(args *serializer.RawMessage, reply *serializer.RawMessage) error {
+	return p.upstream.Call("ArithService.Add", args, reply)
+}
+
+func (p *ProxyService) Div(args *serializer.RawMessage, reply *serializer.RawMessage) error {
+	return p.upstream.Call("ArithService.Div", args, reply)
+}
+
+// DivRequest the divisor is checked before the handler is called
+type DivRequest struct {
+	js.ArithRequest
+}
+
+func (r *DivRequest) Validate() error {
+	if r.B == 0 {
+		return errors.New("divisor must not be zero")
+	}
+	if r.A < 0 {
+		return status.New(status.OutOfRange, "dividend must not be negative")
+	}
+	return nil
+}
+
+// ValidateService handlers only receive valid args
+type ValidateService struct{}
+
+func (*ValidateService) Div(args *DivRequest, reply *js.ArithResponse) error {
+	reply.C = args.A / args.B
+	return nil
+}
+
+// DivValue takes the args by value, Validate is defined on the pointer
+func (*ValidateService) DivValue(args DivRequest, reply *js.ArithResponse) error {
+	reply.C = args.A / args.B
+	return nil
+}
+
+// PanicService handlers that panic
+type PanicService struct{}
+
+func (*PanicService) Div(args *js.ArithRequest, reply *js.ArithResponse) error {
+	reply.C = args.A / args.B
+	if args.B == 0 {
+		var m map[string]int
+		m["divided is zero"]++ // panic: assignment to entry in nil map
+	}
+	return nil
+}
+
+// PushService calls back the services registered by the client
+type PushService struct{}
+
+// Push calls ClientService.Double of the client, then notifies ClientService.Record
+func (*PushService) Push(ctx context.Context, args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	peer, ok := PeerFromContext(ctx)
+	if !ok {
+		return errors.New("no peer in context")
+	}
+	if err := peer.Call("ClientService.Double", args, reply); err != nil {
+		return err
+	}
+	return peer.Notify("ClientService.Record", reply)
+}
+
+// ClientService is registered by the client and called back by PushService
+type ClientService struct {
+	records chan float64
+}
+
+func (*ClientService) Double(args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	reply.C = args.A * 2
+	return nil
+}
+
+func (s *ClientService) Record(args *pb.ArithResponse, reply *pb.ArithResponse) error {
+	s.records <- args.C
+	return nil
+}
+
+// BlockService blocks the calls with a positive args.A until release is closed
+type BlockService struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *BlockService) Block(args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	if args.A > 0 {
+		s.started <- struct{}{}
+		<-s.release
+	}
+	reply.C = args.A
+	return nil
+}
+
 func TestServer_Register(t *testing.T) {
 	server := NewServer()
 	err := server.RegisterName("ArithService", new(pb.ArithService))
 	assert.Equal(t, nil, err)
 	err = server.Register(new(pb.ArithService))
 	assert.Equal(t, errors.New("rpc: service already defined: ArithService"), err)
+
+	// 已弃用的 net/rpc 服务端与序列化器字段仍然可用
+	err = server.Server.Register(new(pb.ArithService))
+	assert.Equal(t, errors.New("rpc: service already defined: ArithService"), err)
+	assert.Equal(t, serializer.NewProtoSerializer(), server.Serializer)
 }
 
 // --------------------------client---------------------------
@@ -171,15 +433,45 @@ func TestNewClientWithZlibCompress(t *testing.T) {
 	client_call(t, compressor.Zlib, AsyncCall)
 }
 
+// TestNewClientWithZstdCompress test zstd compressor
+func TestNewClientWithZstdCompress(t *testing.T) {
+	client_call(t, compressor.Zstd, Call)
+	client_call(t, compressor.Zstd, AsyncCall)
+}
+
+// TestNewClientWithLZ4Compress test lz4 compressor
+func TestNewClientWithLZ4Compress(t *testing.T) {
+	client_call(t, compressor.LZ4, Call)
+	client_call(t, compressor.LZ4, AsyncCall)
+}
+
 // TestNewClientWithSerializer .
 func TestNewClientWithSerializer(t *testing.T) {
+	serializers := []struct {
+		name       string
+		addr       string
+		serializer serializer.Serializer
+	}{
+		{"json", ":8009", serializer.NewJsonSerializer()},
+		{"msgpack", ":8016", serializer.NewMsgpackSerializer()},
+		{"cbor", ":8017", serializer.NewCBORSerializer()},
+		{"gob", ":8018", serializer.NewGobSerializer()},
+		{"json to protojson", ":8019", serializer.NewJsonSerializer()},
+	}
+	for _, s := range serializers {
+		t.Run(s.name, func(t *testing.T) {
+			serializer_call(t, s.addr, s.serializer)
+		})
+	}
+}
 
-	conn, err := net.Dial("tcp", ":8009")
+func serializer_call(t *testing.T, addr string, s serializer.Serializer) {
+	conn, err := net.Dial("tcp", addr)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer conn.Close()
-	client := NewClient(conn, WithSerializer(serializer.NewJsonSerializer()))
+	client := NewClient(conn, WithSerializer(s))
 	defer client.Close()
 
 	type expect struct {
@@ -202,6 +494,35 @@ func TestNewClientWithSerializer(t *testing.T) {
 				reply: &js.ArithResponse{C: 25},
 			},
 		},
+		{
+			client:         client,
+			name:           "test-2",
+			serviceMenthod: "ArithService.Div",
+			arg:            &js.ArithRequest{A: 20, B: 0},
+			expect: expect{
+				reply: &js.ArithResponse{},
+				err:   rpc.ServerError("divided is zero"),
+			},
+		},
+		{
+			client:         client,
+			name:           "test-3",
+			serviceMenthod: "ArithService.Pow",
+			arg:            &js.ArithRequest{A: 20, B: 2},
+			expect: expect{
+				reply: &js.ArithResponse{},
+				err:   rpc.ServerError("rpc: can't find method ArithService.Pow"),
+			},
+		},
+		{
+			client:         client,
+			name:           "test-4",
+			serviceMenthod: "ArithService.Mul",
+			arg:            &js.ArithRequest{A: 20, B: 5},
+			expect: expect{
+				reply: &js.ArithResponse{C: 100},
+			},
+		},
 	}
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
@@ -212,3 +533,926 @@ func TestNewClientWithSerializer(t *testing.T) {
 		})
 	}
 }
+
+// TestNewClientWithProtoJSONSerializer .
+func TestNewClientWithProtoJSONSerializer(t *testing.T) {
+	conn, err := net.Dial("tcp", ":8019")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewClient(conn, WithSerializer(serializer.NewProtoJSONSerializer(serializer.WithEmitUnpopulated())))
+	defer client.Close()
+
+	reply := &pb.ArithResponse{}
+	err = client.Call("ArithService.Sub", &pb.ArithRequest{A: 5, B: 5}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(0), reply.C)
+
+	err = client.Call("ArithService.Mul", &pb.ArithRequest{A: 20, B: 5}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(100), reply.C)
+}
+
+// TestNewClientWithRawMessage .
+func TestNewClientWithRawMessage(t *testing.T) {
+	conn, err := net.Dial("tcp", ":8020")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewClient(conn, WithCompress(compressor.Gzip))
+	defer client.Close()
+
+	// 代理不解码请求与响应
+	reply := &pb.ArithResponse{}
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(25), reply.C)
+
+	err = client.Call("ArithService.Div", &pb.ArithRequest{A: 20, B: 0}, reply)
+	assert.Equal(t, rpc.ServerError("divided is zero"), err)
+
+	// 客户端同样可以直接发送编码好的请求
+	args, err := serializer.NewProtoSerializer().Marshal(&pb.ArithRequest{A: 20, B: 5})
+	assert.Equal(t, nil, err)
+	var raw serializer.RawMessage
+	err = client.Call("ArithService.Add", serializer.RawMessage(args), &raw)
+	assert.Equal(t, nil, err)
+	reply = &pb.ArithResponse{}
+	assert.Equal(t, nil, serializer.NewProtoSerializer().Unmarshal(raw, reply))
+	assert.Equal(t, float64(25), reply.C)
+}
+
+// TestNewClientWithStreamDecoder the bodies left by a failed stream decode are discarded
+func TestNewClientWithStreamDecoder(t *testing.T) {
+	conn, err := net.Dial("tcp", ":8016")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewClient(conn, WithSerializer(serializer.NewMsgpackSerializer()))
+	defer client.Close()
+
+	// fixmap(1) 的值缺失，后面还有未读的字节
+	reply := &js.ArithResponse{}
+	err = client.Call("ArithService.Add", serializer.RawMessage{0x81, 0xa1, 0x61, 0xc1, 0xc1}, reply)
+	assert.NotEqual(t, nil, err)
+
+	err = client.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(25), reply.C)
+}
+
+// TestServer_Validate .
+func TestServer_Validate(t *testing.T) {
+	conn, err := net.Dial("tcp", ":8009")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewClient(conn, WithSerializer(serializer.NewJsonSerializer()))
+	defer client.Close()
+
+	type expect struct {
+		reply *js.ArithResponse
+		code  status.Code
+		msg   string
+	}
+	cases := []struct {
+		name           string
+		serviceMenthod string
+		arg            *DivRequest
+		expect         expect
+	}{
+		{"test-1", "ValidateService.Div", &DivRequest{js.ArithRequest{A: 20, B: 5}},
+			expect{reply: &js.ArithResponse{C: 4}, code: status.OK}},
+		{"test-2", "ValidateService.Div", &DivRequest{js.ArithRequest{A: 20}},
+			expect{reply: &js.ArithResponse{}, code: status.InvalidArgument, msg: "divisor must not be zero"}},
+		{"test-3", "ValidateService.Div", &DivRequest{js.ArithRequest{A: -20, B: 5}},
+			expect{reply: &js.ArithResponse{}, code: status.OutOfRange, msg: "dividend must not be negative"}},
+		{"test-4", "ValidateService.DivValue", &DivRequest{js.ArithRequest{A: 20, B: 5}},
+			expect{reply: &js.ArithResponse{C: 4}, code: status.OK}},
+		{"test-5", "ValidateService.DivValue", &DivRequest{js.ArithRequest{A: 20}},
+			expect{reply: &js.ArithResponse{}, code: status.InvalidArgument, msg: "divisor must not be zero"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			reply := &js.ArithResponse{}
+			err := client.Call(c.serviceMenthod, c.arg, reply)
+			s := status.Convert(err)
+			assert.Equal(t, c.expect.code, s.Code())
+			assert.Equal(t, c.expect.msg, s.Message())
+			assert.Equal(t, c.expect.reply, reply)
+		})
+	}
+}
+
+// TestServer_Interceptor .
+func TestServer_Interceptor(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	record := func(name string) Interceptor {
+		return func(ctx context.Context, info *CallInfo, next Handler) error {
+			mu.Lock()
+			calls = append(calls, name+":"+info.ServiceMethod)
+			mu.Unlock()
+			return next(ctx, info)
+		}
+	}
+	reject := func(ctx context.Context, info *CallInfo, next Handler) error {
+		if info.ServiceMethod == "ArithService.Div" {
+			return status.New(status.PermissionDenied, "division is disabled")
+		}
+		if args := info.Args.(*js.ArithRequest); args.A < 0 { // 拦截器可以读取参数
+			return status.New(status.InvalidArgument, "a must not be negative")
+		}
+		err := next(ctx, info)
+		info.Reply.(*js.ArithResponse).C *= 10 // 也可以修改响应
+		return err
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	server := NewServer(WithSerializer(serializer.NewJsonSerializer()),
+		WithInterceptor(record("first"), record("second")), WithInterceptor(reject))
+	assert.Equal(t, nil, server.Register(new(js.ArithService)))
+	go server.Serve(lis)
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	assert.Equal(t, nil, err)
+	client := NewClient(conn, WithSerializer(serializer.NewJsonSerializer()))
+	defer client.Close()
+
+	reply := &js.ArithResponse{}
+	err = client.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(250), reply.C)
+	assert.Equal(t, []string{"first:ArithService.Add", "second:ArithService.Add"}, calls)
+
+	err = client.Call("ArithService.Div", &js.ArithRequest{A: 20, B: 5}, &js.ArithResponse{})
+	assert.Equal(t, status.PermissionDenied, status.Convert(err).Code())
+	err = client.Call("ArithService.Add", &js.ArithRequest{A: -20, B: 5}, &js.ArithResponse{})
+	assert.Equal(t, status.InvalidArgument, status.Convert(err).Code())
+	assert.Equal(t, 6, len(calls))
+}
+
+// TestClient_CallWithMetadata the metadata of the response is returned by successful
+// calls as well as in the *ResponseError of failed calls
+func TestClient_CallWithMetadata(t *testing.T) {
+	served := func(ctx context.Context, info *CallInfo, next Handler) error {
+		SetResponseMetadata(ctx, "x-served-by", "tinyrpc")
+		return next(ctx, info)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	server := NewServer(WithSerializer(serializer.NewJsonSerializer()), WithInterceptor(served))
+	assert.Equal(t, nil, server.Register(new(js.ArithService)))
+	go server.Serve(lis)
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	assert.Equal(t, nil, err)
+	client := NewClient(conn, WithSerializer(serializer.NewJsonSerializer()))
+	defer client.Close()
+
+	reply := &js.ArithResponse{}
+	md, err := client.CallWithMetadata("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(25), reply.C)
+	assert.Equal(t, map[string]string{"x-served-by": "tinyrpc"}, md)
+
+	md, err = client.CallWithMetadata("ArithService.Div", &js.ArithRequest{A: 20, B: 0}, &js.ArithResponse{})
+	assert.Equal(t, map[string]string{"x-served-by": "tinyrpc"}, md)
+	var respErr *ResponseError
+	assert.True(t, errors.As(err, &respErr))
+	assert.Equal(t, md, respErr.Metadata)
+}
+
+func TestServer_PanicRecovery(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	server := NewServer(WithSerializer(serializer.NewJsonSerializer()))
+	assert.Equal(t, nil, server.Register(new(PanicService)))
+	go server.Serve(lis)
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	assert.Equal(t, nil, err)
+	client := NewClient(conn, WithSerializer(serializer.NewJsonSerializer()))
+	defer client.Close()
+
+	err = client.Call("PanicService.Div", &js.ArithRequest{A: 20, B: 0}, &js.ArithResponse{})
+	assert.Equal(t, status.Internal, status.Convert(err).Code())
+	assert.Equal(t, "panic in PanicService.Div", status.Convert(err).Message())
+	assert.Equal(t, uint64(1), server.Panics())
+
+	// 连接在 panic 之后仍然可用
+	reply := &js.ArithResponse{}
+	assert.Equal(t, nil, client.Call("PanicService.Div", &js.ArithRequest{A: 20, B: 5}, reply))
+	assert.Equal(t, float64(4), reply.C)
+	assert.Equal(t, uint64(1), server.Panics())
+
+	// WithCrashOnPanic 不恢复 panic
+	crash := NewServer(WithCrashOnPanic())
+	assert.Equal(t, nil, crash.Register(new(PanicService)))
+	svci, _ := crash.serviceMap.Load("PanicService")
+	svc := svci.(*service)
+	info := &CallInfo{ServiceMethod: "PanicService.Div", svc: svc, mtype: svc.method["Div"],
+		argv: reflect.ValueOf(&js.ArithRequest{A: 20}), replyv: reflect.ValueOf(&js.ArithResponse{})}
+	assert.Panics(t, func() { crash.handle(context.Background(), info) })
+	assert.Equal(t, uint64(0), crash.Panics())
+}
+
+// TestNewClientWithGobSerializer_Concurrent gob sessions rely on messages being
+// decoded in the order they are encoded
+func TestNewClientWithGobSerializer_Concurrent(t *testing.T) {
+	conn, err := net.Dial("tcp", ":8018")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewClient(conn, WithSerializer(serializer.NewGobSerializer()))
+	defer client.Close()
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reply := &js.ArithResponse{}
+			err := client.Call("ArithService.Add", &js.ArithRequest{A: float64(i), B: 1}, reply)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, float64(i+1), reply.C)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// corruptConn flips the last byte of the first write to conn
+type corruptConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *corruptConn) Write(p []byte) (int, error) {
+	c.once.Do(func() {
+		p = append([]byte(nil), p...)
+		p[len(p)-1] ^= 0xff
+	})
+	return c.Conn.Write(p)
+}
+
+// TestNewClientWithGobSerializer_Corrupted the gob session of the server can not decode
+// the following requests once a request body fails the checksum, the connection is closed
+func TestNewClientWithGobSerializer_Corrupted(t *testing.T) {
+	conn, err := net.Dial("tcp", ":8018")
+	assert.Equal(t, nil, err)
+	client := NewClient(&corruptConn{Conn: conn},
+		WithSerializer(serializer.NewGobSerializer()), WithChecksum(checksum.CRC32))
+	defer client.Close()
+
+	err = client.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, &js.ArithResponse{})
+	assert.NotEqual(t, nil, err)
+	err = client.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, &js.ArithResponse{})
+	assert.Equal(t, rpc.ErrShutdown, err)
+
+	// 新连接上的会话不受影响
+	conn, err = net.Dial("tcp", ":8018")
+	assert.Equal(t, nil, err)
+	client = NewClient(conn, WithSerializer(serializer.NewGobSerializer()), WithChecksum(checksum.CRC32))
+	defer client.Close()
+	reply := &js.ArithResponse{}
+	err = client.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(25), reply.C)
+}
+
+// TestNewClientWithChecksum .
+func TestNewClientWithChecksum(t *testing.T) {
+	type expect struct {
+		reply *pb.ArithResponse
+		err   error
+	}
+	cases := []struct {
+		name   string
+		addr   string
+		opts   []Option
+		expect expect
+	}{
+		{
+			"test-none",
+			":8008",
+			[]Option{WithChecksum(checksum.None)},
+			expect{&pb.ArithResponse{C: 25}, nil},
+		},
+		{
+			"test-crc32c",
+			":8008",
+			[]Option{WithChecksum(checksum.CRC32C)},
+			expect{&pb.ArithResponse{C: 25}, nil},
+		},
+		{
+			"test-xxhash64",
+			":8008",
+			[]Option{WithChecksum(checksum.XXHash64)},
+			expect{&pb.ArithResponse{C: 25}, nil},
+		},
+		{
+			"test-hmac-sha256",
+			":8010",
+			[]Option{WithChecksum(checksum.HMACSHA256), WithChecksumKey([]byte("tinyrpc"))},
+			expect{&pb.ArithResponse{C: 25}, nil},
+		},
+		{
+			"test-hmac-sha256-wrong-key",
+			":8010",
+			[]Option{WithChecksum(checksum.HMACSHA256), WithChecksumKey([]byte("other"))},
+			// 服务端的错误响应同样无法通过客户端密钥的校验
+			expect{&pb.ArithResponse{}, codec.ErrUnexpectedChecksum},
+		},
+		{
+			"test-crc32-to-hmac-server",
+			":8010",
+			[]Option{WithChecksum(checksum.CRC32)},
+			expect{&pb.ArithResponse{}, rpc.ServerError(codec.ErrChecksumTypeMismatch.Error())},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", c.addr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer conn.Close()
+			client := NewClient(conn, c.opts...)
+			defer client.Close()
+
+			reply := &pb.ArithResponse{}
+			err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, reply)
+			assert.Equal(t, true, reflect.DeepEqual(c.expect.reply.C, reply.C))
+			assert.Equal(t, c.expect.err, err)
+		})
+	}
+}
+
+// TestNewClientWithChecksum_ForgedError .
+func TestNewClientWithChecksum_ForgedError(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	defer lis.Close()
+	// 不知道密钥的对端伪造错误响应、元数据与字典确认
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Read(make([]byte, 1024))
+		h := &header.ResponseHeader{
+			Error:        "forged by mitm",
+			ChecksumType: checksum.HMACSHA256,
+			Checksum:     []byte("garbage"),
+			DictID:       1,
+			Metadata:     map[string]string{RetryAfterKey: "999999"},
+		}
+		data := h.Marshal()
+		frame := binary.AppendUvarint([]byte{1}, uint64(len(data))) // 响应帧
+		conn.Write(append(frame, data...))
+		conn.Read(make([]byte, 1))
+	}()
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	assert.Equal(t, nil, err)
+	client := NewClient(conn, WithChecksum(checksum.HMACSHA256), WithChecksumKey([]byte("tinyrpc")), WithDict(1))
+	defer client.Close()
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, &pb.ArithResponse{})
+	assert.Equal(t, codec.ErrUnexpectedChecksum, err)
+	_, ok := RetryAfter(err)
+	assert.Equal(t, false, ok)
+}
+
+// tamperConn rewrites the bytes written to conn
+type tamperConn struct {
+	net.Conn
+	old, new []byte
+}
+
+func (c *tamperConn) Write(p []byte) (int, error) {
+	if _, err := c.Conn.Write(bytes.ReplaceAll(p, c.old, c.new)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// TestNewClientWithChecksum_Header keyed checksums also cover the request header
+func TestNewClientWithChecksum_Header(t *testing.T) {
+	type expect struct {
+		reply *pb.ArithResponse
+		err   error
+	}
+	cases := []struct {
+		name   string
+		addr   string
+		opts   []Option
+		expect expect
+	}{
+		{"test-crc32", ":8008", []Option{WithChecksum(checksum.CRC32)},
+			expect{&pb.ArithResponse{C: 100}, nil}},
+		{"test-hmac-sha256", ":8010", []Option{WithChecksum(checksum.HMACSHA256), WithChecksumKey([]byte("tinyrpc"))},
+			expect{&pb.ArithResponse{}, rpc.ServerError(codec.ErrUnexpectedChecksum.Error())}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", c.addr)
+			assert.Equal(t, nil, err)
+			client := NewClient(&tamperConn{conn, []byte("ArithService.Add"), []byte("ArithService.Mul")}, c.opts...)
+			defer client.Close()
+
+			reply := &pb.ArithResponse{}
+			err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, reply)
+			assert.Equal(t, c.expect.reply.C, reply.C)
+			assert.Equal(t, c.expect.err, err)
+		})
+	}
+
+	// 服务端无法计算请求的校验算法时关闭连接
+	conn, err := net.Dial("tcp", ":8008")
+	assert.Equal(t, nil, err)
+	client := NewClient(conn, WithChecksum(checksum.HMACSHA256), WithChecksumKey([]byte("tinyrpc")))
+	defer client.Close()
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, &pb.ArithResponse{})
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+// recordConn records the bytes written to and read from conn
+type recordConn struct {
+	net.Conn
+	mu      sync.Mutex
+	written bytes.Buffer
+	read    bytes.Buffer
+}
+
+func (c *recordConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.written.Write(p)
+	c.mu.Unlock()
+	return c.Conn.Write(p)
+}
+
+func (c *recordConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	c.read.Write(p[:n])
+	c.mu.Unlock()
+	return n, err
+}
+
+// frameCompressType returns the compress type of the first frame in data,
+// a frame starts with its type and the uvarint length of the header,
+// and both headers start with the little endian compress type
+func frameCompressType(data []byte) compressor.CompressType {
+	_, n := binary.Uvarint(data[1:])
+	return compressor.CompressType(binary.LittleEndian.Uint16(data[1+n:]))
+}
+
+// frameHeader returns the header of the first frame in data
+func frameHeader(data []byte) []byte {
+	size, n := binary.Uvarint(data[1:])
+	return data[1+n : 1+n+int(size)]
+}
+
+// TestNewClientWithDict .
+func TestNewClientWithDict(t *testing.T) {
+	conn, err := net.Dial("tcp", ":8015")
+	if err != nil {
+		log.Fatal(err)
+	}
+	client := NewClient(conn)
+	for i := 0; i < 50; i++ {
+		err = client.Call("ArithService.Mul", &pb.ArithRequest{A: float64(i), B: 3}, &pb.ArithResponse{})
+		assert.Equal(t, nil, err)
+	}
+	client.Close()
+
+	// 用服务端采样的 payload 训练字典，两端注册同一个字典
+	dict, err := dictSampler.Train(1024)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, compressor.RegisterDict(1, dict))
+	t.Cleanup(func() { compressor.UnregisterDict(1) })
+
+	cases := []struct {
+		name     string
+		compress compressor.CompressType
+		dictID   uint32
+		accepted uint32
+	}{
+		{"test-registered", compressor.Zstd, 1, 1},
+		{"test-unregistered", compressor.Zstd, 2, 0},
+		// gzip 不支持字典，服务端不确认
+		{"test-not-supported", compressor.Gzip, 1, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", ":8015")
+			if err != nil {
+				log.Fatal(err)
+			}
+			rc := &recordConn{Conn: conn}
+			client := NewClient(rc, WithCompress(c.compress), WithDict(c.dictID))
+			defer client.Close()
+
+			for i, usesDict := range []bool{false, c.accepted != 0} {
+				reply := &pb.ArithResponse{}
+				err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: float64(i)}, reply)
+				assert.Equal(t, nil, err)
+				assert.Equal(t, float64(20+i), reply.C)
+
+				rc.mu.Lock()
+				reqH, respH := &header.RequestHeader{}, &header.ResponseHeader{}
+				assert.Equal(t, nil, reqH.Unmarshal(frameHeader(rc.written.Bytes())))
+				assert.Equal(t, nil, respH.Unmarshal(frameHeader(rc.read.Bytes())))
+				rc.written.Reset()
+				rc.read.Reset()
+				rc.mu.Unlock()
+
+				// 第一个请求只提议字典，服务端确认后才使用
+				assert.Equal(t, c.dictID, reqH.GetDictID())
+				assert.Equal(t, usesDict, reqH.UsesDict())
+				assert.Equal(t, c.accepted, respH.GetDictID())
+				assert.Equal(t, c.accepted != 0, respH.UsesDict())
+			}
+		})
+	}
+}
+
+// TestNewClientWithCompressThreshold .
+func TestNewClientWithCompressThreshold(t *testing.T) {
+	cases := []struct {
+		name      string
+		addr      string
+		threshold int
+		request   compressor.CompressType
+		response  compressor.CompressType
+	}{
+		{"test-always-compress", ":8008", 0, compressor.Gzip, compressor.Gzip},
+		{"test-below-threshold", ":8008", 64, compressor.Raw, compressor.Gzip},
+		{"test-not-shrunk", ":8008", 1, compressor.Raw, compressor.Gzip},
+		{"test-server-threshold", ":8013", 0, compressor.Gzip, compressor.Raw},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", c.addr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			rc := &recordConn{Conn: conn}
+			client := NewClient(rc, WithCompress(compressor.Gzip), WithCompressThreshold(c.threshold))
+			defer client.Close()
+
+			reply := &pb.ArithResponse{}
+			err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, reply)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, float64(25), reply.C)
+
+			rc.mu.Lock()
+			defer rc.mu.Unlock()
+			assert.Equal(t, c.request, frameCompressType(rc.written.Bytes()))
+			assert.Equal(t, c.response, frameCompressType(rc.read.Bytes()))
+		})
+	}
+}
+
+// TestNewClientWithAcceptCompress .
+func TestNewClientWithAcceptCompress(t *testing.T) {
+	cases := []struct {
+		name     string
+		addr     string
+		opts     []Option
+		request  compressor.CompressType
+		response compressor.CompressType
+	}{
+		{
+			"test-preferred",
+			":8008",
+			[]Option{WithCompress(compressor.Gzip), WithAcceptCompress(compressor.Zstd, compressor.Gzip)},
+			compressor.Gzip,
+			compressor.Zstd,
+		},
+		{
+			"test-policy-not-accepted",
+			":8014",
+			[]Option{WithCompress(compressor.Gzip)},
+			compressor.Gzip,
+			compressor.Gzip,
+		},
+		{
+			"test-policy-accepted",
+			":8014",
+			[]Option{WithCompress(compressor.Gzip), WithAcceptCompress(compressor.Gzip, compressor.Zstd)},
+			compressor.Gzip,
+			compressor.Zstd,
+		},
+		{
+			"test-raw-request",
+			":8014",
+			[]Option{WithAcceptCompress(compressor.Zstd)},
+			compressor.Raw,
+			compressor.Zstd,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", c.addr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			rc := &recordConn{Conn: conn}
+			client := NewClient(rc, c.opts...)
+			defer client.Close()
+
+			reply := &pb.ArithResponse{}
+			err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, reply)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, float64(25), reply.C)
+
+			rc.mu.Lock()
+			defer rc.mu.Unlock()
+			assert.Equal(t, c.request, frameCompressType(rc.written.Bytes()))
+			assert.Equal(t, c.response, frameCompressType(rc.read.Bytes()))
+		})
+	}
+}
+
+// TestServer_Backpressure .
+func TestServer_Backpressure(t *testing.T) {
+	cases := []struct {
+		name string
+		opt  Option
+	}{
+		{"test-worker-pool", WithWorkerPool(1, 0)},
+		{"test-conn-queue-limit", WithConnQueueLimit(1)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			assert.Equal(t, nil, err)
+			server := NewServer(c.opt)
+			defer server.Close()
+			svc := &BlockService{started: make(chan struct{}, 1), release: make(chan struct{})}
+			assert.Equal(t, nil, server.Register(svc))
+			go server.Serve(lis)
+
+			conn, err := net.Dial("tcp", lis.Addr().String())
+			assert.Equal(t, nil, err)
+			client := NewClient(conn)
+			defer client.Close()
+
+			// the first call occupies the only slot
+			slow := client.AsyncCall("BlockService.Block", &pb.ArithRequest{A: 200}, &pb.ArithResponse{})
+			<-svc.started
+
+			err = client.Call("BlockService.Block", &pb.ArithRequest{A: 0}, &pb.ArithResponse{})
+			assert.Equal(t, status.ResourceExhausted, status.Convert(err).Code())
+
+			close(svc.release)
+			call := <-slow
+			assert.Equal(t, nil, call.Error)
+			assert.Equal(t, float64(200), call.Reply.(*pb.ArithResponse).C)
+
+			// the slot is released before the response is sent
+			for i := 0; i < 100; i++ {
+				err = client.Call("BlockService.Block", &pb.ArithRequest{A: 0}, &pb.ArithResponse{})
+				assert.Equal(t, nil, err)
+			}
+		})
+	}
+}
+
+// TestNewClientWithWriteBatch .
+func TestNewClientWithWriteBatch(t *testing.T) {
+	cases := []struct {
+		name     string
+		maxDelay time.Duration
+		maxBytes int
+	}{
+		{"test-no-delay", 0, 0},
+		{"test-max-delay", time.Millisecond, 0},
+		{"test-max-bytes", time.Second, 64},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", ":8008")
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer conn.Close()
+			client := NewClient(conn, WithWriteBatch(c.maxDelay, c.maxBytes))
+			defer client.Close()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 100; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					reply := &pb.ArithResponse{}
+					err := client.Call("ArithService.Add", &pb.ArithRequest{A: float64(i), B: 1}, reply)
+					assert.Equal(t, nil, err)
+					assert.Equal(t, float64(i+1), reply.C)
+				}(i)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func benchmarkClientCall(b *testing.B, opts ...Option) {
+	conn, err := net.Dial("tcp", ":8008")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewClient(conn, opts...)
+	defer client.Close()
+
+	b.SetParallelism(64)
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		reply := &pb.ArithResponse{}
+		for p.Next() {
+			if err := client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, reply); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+// BenchmarkClient_Call the last of the requests waiting for the writer flushes them
+func BenchmarkClient_Call(b *testing.B) {
+	benchmarkClientCall(b)
+}
+
+// BenchmarkClient_CallWithWriteBatch flushes from the write loop of the deprecated
+// WithWriteBatch, it is no faster than BenchmarkClient_Call
+func BenchmarkClient_CallWithWriteBatch(b *testing.B) {
+	benchmarkClientCall(b, WithWriteBatch(0, 0))
+}
+
+// BenchmarkClient_CallWithWriteBatchDelay corks requests for up to 100µs
+func BenchmarkClient_CallWithWriteBatchDelay(b *testing.B) {
+	benchmarkClientCall(b, WithWriteBatch(100*time.Microsecond, 0))
+}
+
+// TestClient_Notify .
+func TestClient_Notify(t *testing.T) {
+	conn, err := net.Dial("tcp", ":8008")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewClient(conn)
+	defer client.Close()
+
+	err = client.Notify("NotifyService.Record", &pb.ArithRequest{A: 7})
+	assert.Equal(t, nil, err)
+	select {
+	case args := <-notified:
+		assert.Equal(t, float64(7), args.A)
+	case <-time.After(time.Second):
+		t.Fatal("notification is not executed")
+	}
+
+	// no response is written for the notification, so the next call reads its own response
+	reply := &pb.ArithResponse{}
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(25), reply.C)
+
+	// errors of one-way calls are dropped as well
+	err = client.Notify("NotifyService.Unknown", &pb.ArithRequest{A: 7})
+	assert.Equal(t, nil, err)
+	err = client.Call("ArithService.Mul", &pb.ArithRequest{A: 20, B: 5}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(100), reply.C)
+}
+
+// TestClient_Register .
+func TestClient_Register(t *testing.T) {
+	conn, err := net.Dial("tcp", ":8008")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewClient(conn)
+	defer client.Close()
+
+	records := make(chan float64, 1)
+	err = client.Register(&ClientService{records})
+	assert.Equal(t, nil, err)
+
+	type expect struct {
+		reply *pb.ArithResponse
+		err   error
+	}
+	cases := []struct {
+		name           string
+		serviceMenthod string
+		arg            *pb.ArithRequest
+		expect         expect
+	}{
+		{
+			"test-1",
+			"PushService.Push",
+			&pb.ArithRequest{A: 21},
+			expect{&pb.ArithResponse{C: 42}, nil},
+		},
+		{
+			"test-2",
+			"ArithService.Add",
+			&pb.ArithRequest{A: 20, B: 5},
+			expect{&pb.ArithResponse{C: 25}, nil},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			reply := &pb.ArithResponse{}
+			err := client.Call(c.serviceMenthod, c.arg, reply)
+			assert.Equal(t, c.expect.reply.C, reply.C)
+			assert.Equal(t, c.expect.err, err)
+		})
+	}
+	select {
+	case c := <-records:
+		assert.Equal(t, float64(42), c)
+	case <-time.After(time.Second):
+		t.Fatal("callback notification is not executed")
+	}
+
+	// the server gets an error if the client does not register the callback service
+	conn2, err := net.Dial("tcp", ":8008")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn2.Close()
+	client2 := NewClient(conn2)
+	defer client2.Close()
+	err = client2.Call("PushService.Push", &pb.ArithRequest{A: 21}, &pb.ArithResponse{})
+	assert.Equal(t, rpc.ServerError("rpc: can't find service ClientService.Double"), err)
+}
+
+// TestClient_SlowCallback .
+func TestClient_SlowCallback(t *testing.T) {
+	conn, err := net.Dial("tcp", ":8008")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewClient(conn)
+	defer client.Close()
+
+	// Record blocks until the notification is received, the responses are still read
+	records := make(chan float64)
+	err = client.Register(&ClientService{records})
+	assert.Equal(t, nil, err)
+	for i := 1; i <= 3; i++ {
+		reply := &pb.ArithResponse{}
+		err = client.Call("PushService.Push", &pb.ArithRequest{A: float64(i)}, reply)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, float64(2*i), reply.C)
+	}
+	reply := &pb.ArithResponse{}
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(25), reply.C)
+
+	got := make([]float64, 0, 3)
+	for len(got) < 3 {
+		select {
+		case c := <-records:
+			got = append(got, c)
+		case <-time.After(time.Second):
+			t.Fatal("callback notification is not executed")
+		}
+	}
+	assert.ElementsMatch(t, []float64{2, 4, 6}, got)
+}
+
+// TestServer_Serve .
+func TestServer_Serve(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := NewServer()
+	defer server.Close()
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(lis) }()
+
+	// Serve returns once the listener is closed instead of spinning on Accept
+	lis.Close()
+	select {
+	case err := <-done:
+		assert.Equal(t, true, errors.Is(err, net.ErrClosed))
+	case <-time.After(time.Second):
+		t.Fatal("Serve does not return after the listener is closed")
+	}
+}
@@ -0,0 +1,97 @@
+package tinyrpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"time"
+)
+
+// handshakeTimeout 服务端等待 TLS 握手完成的最长时间
+const handshakeTimeout = 10 * time.Second
+
+// WithTLSConfig serve connections over TLS, set ClientAuth to tls.RequireAndVerifyClientCert
+// and ClientCAs for mutual TLS
+func WithTLSConfig(config *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = config
+	}
+}
+
+// ServeTLS accepts TLS connections with the certificate and key files, the config set
+// by WithTLSConfig is used for the other settings. The files may be empty if the config
+// provides the certificates. Like Serve it returns the error of lis.Accept.
+func (s *Server) ServeTLS(lis net.Listener, certFile, keyFile string) error {
+	config := &tls.Config{}
+	if s.tlsConfig != nil {
+		config = s.tlsConfig.Clone()
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	return s.serve(tls.NewListener(lis, config))
+}
+
+// DialTLS connects to the TLS server at address and creates a client on the connection,
+// set Certificates of config to present a client certificate for mutual TLS
+func DialTLS(network, address string, config *tls.Config, opts ...Option) (*Client, error) {
+	conn, err := tls.Dial(network, address, config)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, opts...), nil
+}
+
+// Identity is the identity of a client that presented a verified certificate
+type Identity struct {
+	Subject        pkix.Name
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+	Chain          []*x509.Certificate // verified chain, from the client certificate to the root
+}
+
+type identityKey struct{}
+
+// IdentityFromContext returns the identity of the client of a mutual TLS connection,
+// it is available to interceptors and handlers that take a context.Context
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
+
+// handshake completes the TLS handshake of conn and returns the context with the
+// identity of the client, ctx is returned as is if conn is not a TLS connection
+func handshake(ctx context.Context, conn net.Conn) (context.Context, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return ctx, nil
+	}
+	hctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(hctx); err != nil {
+		return ctx, err
+	}
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 { // 未校验的客户端证书不作为身份
+		return ctx, nil
+	}
+	chain := state.VerifiedChains[0]
+	leaf := chain[0]
+	return context.WithValue(ctx, identityKey{}, &Identity{
+		Subject:        leaf.Subject,
+		DNSNames:       leaf.DNSNames,
+		EmailAddresses: leaf.EmailAddresses,
+		IPAddresses:    leaf.IPAddresses,
+		URIs:           leaf.URIs,
+		Chain:          chain,
+	}), nil
+}
@@ -0,0 +1,242 @@
+package tinyrpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"tinyrpc/serializer"
+	"tinyrpc/status"
+	js "tinyrpc/test_gen/json"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCert in-memory certificate issued by the test CA
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key, Leaf: c.cert}
+}
+
+// issue creates a certificate from the template signed by parent, a nil parent self-signs it
+func issue(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Equal(t, nil, err)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.Equal(t, nil, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Equal(t, nil, err)
+	return &testCert{cert: cert, key: key}
+}
+
+type testPKI struct {
+	ca     *testCert
+	pool   *x509.CertPool
+	server *testCert
+	client *testCert
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	ca := issue(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "tinyrpc test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	spiffe, _ := url.Parse("spiffe://tinyrpc/client")
+	return &testPKI{
+		ca:   ca,
+		pool: pool,
+		server: issue(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "server"},
+			DNSNames:    []string{"localhost"},
+			IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, ca),
+		client: issue(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "client", Organization: []string{"tinyrpc"}},
+			DNSNames:    []string{"client.tinyrpc"},
+			URIs:        []*url.URL{spiffe},
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, ca),
+	}
+}
+
+// IdentityReply the identity of the caller seen by the server
+type IdentityReply struct {
+	CommonName string
+	DNSNames   []string
+	URIs       []string
+}
+
+type IdentityService struct{}
+
+func (*IdentityService) Whoami(ctx context.Context, args *js.ArithRequest, reply *IdentityReply) error {
+	id, ok := IdentityFromContext(ctx)
+	if !ok {
+		return status.New(status.Unauthenticated, "no client certificate")
+	}
+	reply.CommonName = id.Subject.CommonName
+	reply.DNSNames = id.DNSNames
+	for _, u := range id.URIs {
+		reply.URIs = append(reply.URIs, u.String())
+	}
+	return nil
+}
+
+// serveTLS starts a server with the TLS config on a random port
+func serveTLS(t *testing.T, config *tls.Config, opts ...Option) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	opts = append([]Option{WithSerializer(serializer.NewJsonSerializer()), WithTLSConfig(config)}, opts...)
+	server := NewServer(opts...)
+	assert.Equal(t, nil, server.Register(new(js.ArithService)))
+	assert.Equal(t, nil, server.Register(new(IdentityService)))
+	go server.Serve(lis)
+	return lis.Addr().String()
+}
+
+func TestServer_TLS(t *testing.T) {
+	pki := newTestPKI(t)
+	addr := serveTLS(t, &tls.Config{Certificates: []tls.Certificate{pki.server.tlsCertificate()}})
+
+	client, err := DialTLS("tcp", addr, &tls.Config{RootCAs: pki.pool, ServerName: "localhost"},
+		WithSerializer(serializer.NewJsonSerializer()))
+	assert.Equal(t, nil, err)
+	defer client.Close()
+
+	reply := &js.ArithResponse{}
+	err = client.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(25), reply.C)
+
+	// 未提供客户端证书时没有身份
+	err = client.Call("IdentityService.Whoami", &js.ArithRequest{}, &IdentityReply{})
+	assert.Equal(t, status.Unauthenticated, status.Convert(err).Code())
+
+	// 不信任服务端证书
+	_, err = DialTLS("tcp", addr, &tls.Config{ServerName: "localhost"})
+	var unknownAuthority x509.UnknownAuthorityError
+	assert.True(t, errors.As(err, &unknownAuthority))
+}
+
+func TestServer_MutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	var intercepted *Identity
+	addr := serveTLS(t, &tls.Config{
+		Certificates: []tls.Certificate{pki.server.tlsCertificate()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pki.pool,
+	}, WithInterceptor(func(ctx context.Context, info *CallInfo, next Handler) error {
+		intercepted, _ = IdentityFromContext(ctx)
+		return next(ctx, info)
+	}))
+
+	client, err := DialTLS("tcp", addr, &tls.Config{
+		RootCAs:      pki.pool,
+		ServerName:   "localhost",
+		Certificates: []tls.Certificate{pki.client.tlsCertificate()},
+	}, WithSerializer(serializer.NewJsonSerializer()))
+	assert.Equal(t, nil, err)
+	defer client.Close()
+
+	reply := &IdentityReply{}
+	err = client.Call("IdentityService.Whoami", &js.ArithRequest{}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &IdentityReply{
+		CommonName: "client",
+		DNSNames:   []string{"client.tinyrpc"},
+		URIs:       []string{"spiffe://tinyrpc/client"},
+	}, reply)
+	assert.Equal(t, []string{"tinyrpc"}, intercepted.Subject.Organization)
+	assert.Equal(t, 2, len(intercepted.Chain))
+
+	// 没有客户端证书的连接在握手时被拒绝
+	client, err = DialTLS("tcp", addr, &tls.Config{RootCAs: pki.pool, ServerName: "localhost"},
+		WithSerializer(serializer.NewJsonSerializer()))
+	if err == nil { // TLS 1.3 客户端在服务端校验证书前完成握手，错误在调用时返回
+		defer client.Close()
+		err = client.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, &js.ArithResponse{})
+	}
+	assert.NotEqual(t, nil, err)
+
+	// 其它 CA 签发的客户端证书
+	other := newTestPKI(t)
+	client, err = DialTLS("tcp", addr, &tls.Config{
+		RootCAs:      pki.pool,
+		ServerName:   "localhost",
+		Certificates: []tls.Certificate{other.client.tlsCertificate()},
+	}, WithSerializer(serializer.NewJsonSerializer()))
+	if err == nil {
+		defer client.Close()
+		err = client.Call("ArithService.Add", &js.ArithRequest{A: 20, B: 5}, &js.ArithResponse{})
+	}
+	assert.NotEqual(t, nil, err)
+}
+
+func TestServer_ServeTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	key, err := x509.MarshalECPrivateKey(pki.server.key)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, os.WriteFile(certFile, pemBlock("CERTIFICATE", pki.server.cert.Raw), 0600))
+	assert.Equal(t, nil, os.WriteFile(keyFile, pemBlock("EC PRIVATE KEY", key), 0600))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	server := NewServer(WithSerializer(serializer.NewJsonSerializer()))
+	assert.Equal(t, nil, server.Register(new(js.ArithService)))
+	done := make(chan error, 1)
+	go func() { done <- server.ServeTLS(lis, certFile, keyFile) }()
+
+	client, err := DialTLS("tcp", lis.Addr().String(), &tls.Config{RootCAs: pki.pool, ServerName: "localhost"},
+		WithSerializer(serializer.NewJsonSerializer()))
+	assert.Equal(t, nil, err)
+	defer client.Close()
+	reply := &js.ArithResponse{}
+	err = client.Call("ArithService.Mul", &js.ArithRequest{A: 20, B: 5}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(100), reply.C)
+
+	assert.NotEqual(t, nil, server.ServeTLS(lis, filepath.Join(dir, "missing.crt"), keyFile))
+
+	lis.Close()
+	select {
+	case err := <-done:
+		assert.Equal(t, true, errors.Is(err, net.ErrClosed))
+	case <-time.After(time.Second):
+		t.Fatal("ServeTLS does not return after the listener is closed")
+	}
+}
+
+func pemBlock(typ string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+}
@@ -0,0 +1,32 @@
+package tinyrpc
+
+import (
+	"reflect"
+	"tinyrpc/status"
+)
+
+// Validator is implemented by args that check themselves, e.g. the messages with
+// validation rules generated by protoc-gen-tinyrpc. The server validates the args
+// before calling the handler and rejects invalid ones with status.InvalidArgument.
+type Validator interface {
+	Validate() error
+}
+
+// validate checks the args of a request, errors carrying a status are returned as is
+func validate(argv reflect.Value) error {
+	v, ok := argv.Interface().(Validator)
+	if !ok && argv.CanAddr() { // 值类型参数的 Validate 方法可能定义在指针上
+		v, ok = argv.Addr().Interface().(Validator)
+	}
+	if !ok {
+		return nil
+	}
+	err := v.Validate()
+	if err == nil {
+		return nil
+	}
+	if s, ok := status.FromError(err); ok {
+		return s
+	}
+	return status.New(status.InvalidArgument, err.Error())
+}